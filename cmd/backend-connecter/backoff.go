@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// 再接続の待ち時間を指数的に伸ばす
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	attempt uint
+}
+
+func newBackoff(min, max time.Duration) *backoff {
+	return &backoff{
+		min: min,
+		max: max,
+	}
+}
+
+// Next は次の待ち時間を返す
+// 同時に再接続が集中しないように半分をランダムにずらす
+func (b *backoff) Next() time.Duration {
+	d := b.min << b.attempt
+	if d <= 0 || d > b.max {
+		d = b.max
+	} else {
+		b.attempt++
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func (b *backoff) Reset() {
+	b.attempt = 0
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+)
+
+// relayが流量を制御しない場合に、ローカルへのリクエストが始まった後に溜めておくBodyChunkの数
+const bodyBufferSize = 16
+
+var (
+	errBodyClosed   = errors.New("body is closed")
+	errBodyOverflow = errors.New("request body overflows the buffer")
+)
+
+// bodyBuffer はrelayから届いたリクエストのボディをローカルのバックエンドが読むまで溜める
+// relayが流量を制御する場合は読み終えたBodyChunkの数をgrantで伝え、remote.BodyWindowを超えて届いたものは受け取らない
+// 制御しない古いrelayの場合は、workerが空くのを待っている間は上限なく溜め、読み始めた後は上限を超えるとpushを待たせる
+type bodyBuffer struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	chunks  [][]byte
+	eof     bool
+	err     error
+	started bool
+	// relayに読み終えたBodyChunkの数を伝える. relayが流量を制御しない場合はnil
+	grant    func(n int)
+	consumed int
+}
+
+// newBodyBuffer はctxが終わると読み込み中のReadにエラーを返すbodyBufferを作る
+func newBodyBuffer(ctx context.Context, grant func(n int)) *bodyBuffer {
+	b := &bodyBuffer{grant: grant}
+	b.cond = sync.NewCond(&b.mu)
+	go func() {
+		<-ctx.Done()
+		b.close(ctx.Err())
+	}()
+	return b
+}
+
+// push はrelayから届いたボディを溜める
+// relayが流量を制御しているのに溜めきれない場合はfalseを返す
+func (b *bodyBuffer) push(data []byte, eof bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.grant == nil && b.started && len(b.chunks) >= bodyBufferSize && b.err == nil {
+		b.cond.Wait()
+	}
+	if b.err != nil || b.eof {
+		return true
+	}
+	if len(data) != 0 {
+		if b.grant != nil && len(b.chunks) >= remote.BodyWindow {
+			return false
+		}
+		b.chunks = append(b.chunks, data)
+	}
+	b.eof = eof
+	b.cond.Broadcast()
+	return true
+}
+
+// close は読み込み中のローカルへのリクエストにerrを返す
+func (b *bodyBuffer) close(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+}
+
+func (b *bodyBuffer) Read(p []byte) (int, error) {
+	n, consumed, err := b.read(p)
+	if consumed != 0 {
+		// relayに送るのを待つことがあるのでロックの外で呼ぶ
+		b.grant(consumed)
+	}
+	return n, err
+}
+
+// read はpに読み込み、relayに伝えるBodyChunkの数を返す
+func (b *bodyBuffer) read(p []byte) (n int, consumed int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.started = true
+	for len(b.chunks) == 0 && !b.eof && b.err == nil {
+		b.cond.Wait()
+	}
+	if len(b.chunks) == 0 {
+		if b.err != nil {
+			return 0, 0, b.err
+		}
+		return 0, 0, io.EOF
+	}
+
+	n = copy(p, b.chunks[0])
+	if n == len(b.chunks[0]) {
+		b.chunks[0] = nil
+		b.chunks = b.chunks[1:]
+		if b.grant != nil {
+			// relayは受け取ったと伝えた分だけ続きを送るので、半分読んだらまとめて伝える
+			b.consumed++
+			if b.consumed >= remote.BodyWindow/2 {
+				consumed = b.consumed
+				b.consumed = 0
+			}
+		}
+	} else {
+		b.chunks[0] = b.chunks[0][n:]
+	}
+	b.cond.Broadcast()
+	return n, consumed, nil
+}
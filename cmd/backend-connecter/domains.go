@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/config"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+)
+
+// upstreams は登録するドメインとローカルのバックエンド. 先頭のものはDomainsを知らない古いrelayにも登録される
+var upstreams []config.DomainUpstream
+
+// displacedDomains は別のバックエンドに奪われたドメイン. 取り返し合わないように再接続しても登録しない
+var displacedDomains = struct {
+	sync.Mutex
+	domains map[string]struct{}
+}{domains: map[string]struct{}{}}
+
+// markDisplaced はドメインを奪われたものとして記録する
+func markDisplaced(domain string) {
+	displacedDomains.Lock()
+	defer displacedDomains.Unlock()
+	displacedDomains.domains[domain] = struct{}{}
+}
+
+// activeUpstreams は奪われていないupstreamsを同じ順に返す
+func activeUpstreams() []config.DomainUpstream {
+	displacedDomains.Lock()
+	defer displacedDomains.Unlock()
+	var us []config.DomainUpstream
+	for _, u := range upstreams {
+		if _, ok := displacedDomains.domains[u.Domain]; !ok {
+			us = append(us, u)
+		}
+	}
+	return us
+}
+
+// loadUpstreams はDOMAINSを読む. 空の場合はBACKEND_HOST_NAMEだけを登録する
+func loadUpstreams() ([]config.DomainUpstream, error) {
+	var us []config.DomainUpstream
+	if defaultConfig.Domains == "" {
+		scheme := defaultConfig.Scheme
+		if defaultConfig.Protocol != "http" {
+			scheme = defaultConfig.Protocol
+		}
+		us = []config.DomainUpstream{{
+			Domain: defaultConfig.BackendHostName,
+			Scheme: scheme,
+			Host:   defaultConfig.BackendHostName,
+		}}
+	} else {
+		var err error
+		us, err = config.ParseDomains(defaultConfig.Domains, defaultConfig.Scheme)
+		if err != nil {
+			return nil, err
+		}
+		if len(us) == 0 {
+			return nil, errors.New("no domain to register")
+		}
+	}
+	for _, u := range us {
+		if _, err := upstreamProtocol(u); err != nil {
+			return nil, err
+		}
+	}
+	return us, nil
+}
+
+// upstreamProtocol はスキームからバックエンドが受け付ける通信の種類を決める
+func upstreamProtocol(u config.DomainUpstream) (remote.Protocol, error) {
+	switch u.Scheme {
+	case "http", "https":
+		return remote.Protocol_PROTOCOL_HTTP, nil
+	case "tcp":
+		return remote.Protocol_PROTOCOL_TCP, nil
+	case "udp":
+		return remote.Protocol_PROTOCOL_UDP, nil
+	}
+	return remote.Protocol_PROTOCOL_HTTP, fmt.Errorf("unsupported scheme %q for %s", u.Scheme, u.Domain)
+}
+
+// connection はrelayに登録する内容を作る. 奪われたドメインは含めない
+// 全て奪われている場合はnilを返す
+func connection() *remote.Connection {
+	con := &remote.Connection{
+		DeveloperName: defaultConfig.DeveloperName,
+		Weight:        int32(defaultConfig.Weight),
+		// Tunnelではリクエストのボディを読むたびにrelayに伝える
+		FlowControl: true,
+	}
+	for _, u := range activeUpstreams() {
+		// loadUpstreamsで確かめているのでエラーにならない
+		p, _ := upstreamProtocol(u)
+		con.Domains = append(con.Domains, &remote.DomainRegistration{
+			Domain:   u.Domain,
+			Protocol: p,
+		})
+	}
+	if len(con.Domains) == 0 {
+		return nil
+	}
+	con.Domain = con.Domains[0].Domain
+	con.Protocol = con.Domains[0].Protocol
+	return con
+}
+
+// upstreamTable はrelayが払い出したバックエンドのIDからリクエストを送るローカルのバックエンドを引く
+type upstreamTable map[string]config.DomainUpstream
+
+// registrations はCONTROL_REGISTEREDで返された登録を返す
+// Registrationsを返さない古いrelayの場合は先頭のドメインだけが登録されている
+func registrations(registered *remote.Control) []*remote.DomainRegistration {
+	if regs := registered.GetRegistrations(); len(regs) != 0 {
+		return regs
+	}
+	return []*remote.DomainRegistration{{
+		Domain:    registered.GetDomain(),
+		BackendId: registered.GetBackendId(),
+	}}
+}
+
+// newUpstreamTable はCONTROL_REGISTEREDで返された登録をconnectionで送ったドメインと同じ順に対応させる
+// 登録できなかったものは含めない
+func newUpstreamTable(registered *remote.Control) upstreamTable {
+	t := upstreamTable{}
+	us := activeUpstreams()
+	for i, reg := range registrations(registered) {
+		if i < len(us) && reg.GetError() == "" {
+			t[reg.GetBackendId()] = us[i]
+		}
+	}
+	return t
+}
+
+// lookup はバックエンドのIDに対応するローカルのバックエンドを返す
+// BackendIdを入れない古いrelayからのリクエストは先頭のものに送る
+func (t upstreamTable) lookup(backendID string) config.DomainUpstream {
+	if u, ok := t[backendID]; ok {
+		return u
+	}
+	return upstreams[0]
+}
+
+// remove はrelayが登録を解除したバックエンドを除き、残っている数を返す
+func (t upstreamTable) remove(backendID string) int {
+	delete(t, backendID)
+	return len(t)
+}
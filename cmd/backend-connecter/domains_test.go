@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/config"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+)
+
+// 奪われたドメインは登録し直さず、残りのドメインとrelayの登録を対応させる
+func TestConnectionSkipsDisplaced(t *testing.T) {
+	defer func(u []config.DomainUpstream) {
+		upstreams = u
+		displacedDomains.domains = map[string]struct{}{}
+	}(upstreams)
+	upstreams = []config.DomainUpstream{
+		{Domain: "a.test", Scheme: "http", Host: "localhost:8080"},
+		{Domain: "b.test", Scheme: "tcp", Host: "localhost:5432"},
+		{Domain: "c.test", Scheme: "http", Host: "localhost:8081"},
+	}
+
+	markDisplaced("a.test")
+	con := connection()
+	if len(con.Domains) != 2 || con.Domains[0].Domain != "b.test" || con.Domains[1].Domain != "c.test" {
+		t.Fatalf("domains: got %v", con.Domains)
+	}
+	if con.Domain != "b.test" || con.Protocol != remote.Protocol_PROTOCOL_TCP {
+		t.Errorf("first domain: got %s %s", con.Domain, con.Protocol)
+	}
+
+	table := newUpstreamTable(&remote.Control{
+		Type: remote.ControlType_CONTROL_REGISTERED,
+		Registrations: []*remote.DomainRegistration{
+			{Domain: "b.test", Error: "domain is drained"},
+			{Domain: "c.test", BackendId: "c"},
+		},
+	})
+	if len(table) != 1 || table.lookup("c").Host != "localhost:8081" {
+		t.Errorf("table: got %v", table)
+	}
+	if n := table.remove("c"); n != 0 {
+		t.Errorf("remaining: got %d, want 0", n)
+	}
+
+	markDisplaced("b.test")
+	markDisplaced("c.test")
+	if con := connection(); con != nil {
+		t.Errorf("connection with all domains displaced: got %v", con)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// displaced はrelayがドメインを別のバックエンドに渡したためにストリームを閉じたかを返す
+func displaced(stream grpc.ClientStream, err error) bool {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Aborted {
+		return false
+	}
+	if len(stream.Trailer().Get(remote.MetadataDisplaced)) != 0 {
+		return true
+	}
+	// トレーラーを返さない古いrelayは理由をメッセージに入れる
+	return st.Message() == errDisplaced.Error()
+}
+
+// connectLegacy はTunnelに対応していないrelayにBackendReceive/BackendSendで接続する
+// 接続できた時点でonConnectedが呼ばれる
+func connectLegacy(client remote.ProxyClient, connectionOpts *remote.Connection, onConnected func()) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.BackendReceive(ctx, connectionOpts)
+	if err != nil {
+		return err
+	}
+	// relayが登録を終えるとバックエンドのIDがヘッダーで返ってくる
+	header, err := stream.Header()
+	if err != nil {
+		return err
+	}
+	ids := header.Get(remote.MetadataBackendID)
+	if len(ids) == 0 {
+		return errors.New("backend id is not returned by relay server")
+	}
+
+	if domains := header.Get(remote.MetadataDomain); len(domains) != 0 {
+		log.Info().Str("backend_id", ids[0]).Str("domain", domains[0]).Msg("registered")
+	}
+
+	sendCtx := metadata.AppendToOutgoingContext(ctx, remote.MetadataBackendID, ids[0])
+	sendStream, err := client.BackendSend(sendCtx)
+	if err != nil {
+		return err
+	}
+
+	sender := newResponseSender(sendStream, defaultConfig.Concurrency)
+	defer func() {
+		if err := sender.Close(); err != nil {
+			log.Warn().Err(err).Msg("failed to close response stream")
+		}
+	}()
+	onConnected()
+
+	pool := newWorkerPool(defaultConfig.Concurrency, sender, cancel)
+	defer pool.Close()
+
+	for {
+		reqWrapper, err := stream.Recv()
+		if err == io.EOF {
+			return errors.New("stream is closed by relay server")
+		}
+		if displaced(stream, err) {
+			markDisplaced(upstreams[0].Domain)
+			return errDisplaced
+		}
+		if err != nil {
+			select {
+			case err := <-pool.Err():
+				return err
+			default:
+				return err
+			}
+		}
+
+		if err := pool.Dispatch(request{ctx: ctx, wrapper: reqWrapper, upstream: upstreams[0]}); err != nil {
+			return err
+		}
+	}
+}
@@ -4,115 +4,167 @@ import (
 	"bytes"
 	"context"
 	"io"
-	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/ieee0824/virtual-neighbor-proxy/config"
 	"github.com/ieee0824/virtual-neighbor-proxy/remote"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var defaultConfig = config.NewBackendConnecterConfig()
 
-func connect(client remote.ProxyClient, connectionOpts *remote.Connection) error {
-	stream, err := client.BackendReceive(context.Background(), connectionOpts)
+// ローカルのバックエンドにリクエストを投げる
+// ctxが取り消されるとローカルへのリクエストも止める
+// bodyがnilの場合はreqWrapperのBodyを使う
+func doRequest(ctx context.Context, upstream config.DomainUpstream, reqWrapper *remote.HttpRequestWrapper, body io.Reader) (*http.Response, error) {
+	req, err := newRequest(ctx, upstream, reqWrapper, body)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return http.DefaultClient.Do(req)
+}
 
-	for {
-		reqWrapper, err := stream.Recv()
-		if err == io.EOF {
-			return nil
+// newRequest はローカルのバックエンドへのリクエストを作る
+func newRequest(ctx context.Context, upstream config.DomainUpstream, reqWrapper *remote.HttpRequestWrapper, body io.Reader) (*http.Request, error) {
+	headers := http.Header{}
+	for _, h := range reqWrapper.GetHeaders() {
+		for _, v := range h.Value {
+			headers.Add(h.Key, v)
 		}
+	}
 
-		headers := http.Header{}
-		for _, h := range reqWrapper.GetHeaders() {
-			for _, v := range h.Value {
-				headers.Set(h.Key, v)
-			}
-		}
+	log.Debug().
+		Str("method", reqWrapper.GetHttpMethod()).
+		Str("url", reqWrapper.GetHttpRequestURL()).
+		Str("connection_id", reqWrapper.GetConnectionId()).
+		Str("domain", reqWrapper.GetDomain()).
+		Interface("header", headers).
+		Int("body_length", len(reqWrapper.GetBody())).
+		Bool("stream_body", reqWrapper.GetStreamBody()).
+		Msg("receive request")
+
+	u, err := url.Parse(reqWrapper.GetHttpRequestURL())
+	if err != nil {
+		return nil, err
+	}
 
-		log.Debug().
-			Str("method", reqWrapper.GetHttpMethod()).
-			Str("url", reqWrapper.GetHttpRequestURL()).
-			Str("connection_id", reqWrapper.GetConnectionId()).
-			Str("domain", reqWrapper.GetDomain()).
-			Interface("header", headers).
-			Int("body_length", len(reqWrapper.GetBody())).
-			Msg("receive request")
-
-		u, err := url.Parse(reqWrapper.GetHttpRequestURL())
-		if err != nil {
-			return err
-		}
+	route := selectRoute(upstream, u.Path, headers)
+	u.Host = route.Host
+	u.Scheme = route.Scheme
+	if route.StripPrefix {
+		u.Path = stripPathPrefix(u.Path, route.PathPrefix)
+		u.RawPath = ""
+	}
 
-		u.Host = defaultConfig.BackendHostName
-		u.Scheme = defaultConfig.Scheme
-
-		var req *http.Request
-		if reqWrapper.GetHttpMethod() == http.MethodGet {
-			r, err := http.NewRequest(
-				reqWrapper.GetHttpMethod(),
-				u.String(),
-				nil,
-			)
-			if err != nil {
-				return err
-			}
-			req = r
-		} else {
-			r, err := http.NewRequest(
-				reqWrapper.GetHttpMethod(),
-				u.String(),
-				bytes.NewBuffer(reqWrapper.GetBody()),
-			)
-			if err != nil {
-				return err
-			}
-			req = r
-		}
+	if body == nil && reqWrapper.GetHttpMethod() != http.MethodGet {
+		body = bytes.NewBuffer(reqWrapper.GetBody())
+	}
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return err
-		}
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
+	req, err := http.NewRequestWithContext(
+		ctx,
+		reqWrapper.GetHttpMethod(),
+		u.String(),
+		body,
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = headers
+	if reqWrapper.GetStreamBody() {
+		// ボディの長さが分かっていればchunkedにせずに送る
+		if l, err := strconv.ParseInt(headers.Get("Content-Length"), 10, 64); err == nil {
+			req.ContentLength = l
 		}
-		resp.Body.Close()
+	}
 
-		respWrapper := &remote.HttpResponseWrapper{
-			ConnectionId: reqWrapper.ConnectionId,
-			Status:       int32(resp.StatusCode),
-			Body:         body,
-			Headers:      map[string]*remote.HttpHeader{},
-		}
+	return req, nil
+}
+
+// wrapResponse はローカルのバックエンドからのレスポンスのステータスとヘッダーを詰める
+func wrapResponse(reqWrapper *remote.HttpRequestWrapper, resp *http.Response) *remote.HttpResponseWrapper {
+	respWrapper := &remote.HttpResponseWrapper{
+		ConnectionId: reqWrapper.ConnectionId,
+		Status:       int32(resp.StatusCode),
+		Headers:      map[string]*remote.HttpHeader{},
+	}
 
-		for key, v := range resp.Header {
-			respWrapper.Headers[key] = &remote.HttpHeader{
-				Key:   key,
-				Value: v,
-			}
+	for key, v := range resp.Header {
+		respWrapper.Headers[key] = &remote.HttpHeader{
+			Key:   key,
+			Value: v,
 		}
+	}
+	return respWrapper
+}
+
+// ローカルへのリクエストが失敗したときにフロントに返すレスポンス
+func badGateway(reqWrapper *remote.HttpRequestWrapper) *remote.HttpResponseWrapper {
+	return &remote.HttpResponseWrapper{
+		ConnectionId: reqWrapper.ConnectionId,
+		Status:       http.StatusBadGateway,
+	}
+}
 
-		sendStream, err := client.BackendSend(context.Background())
-		if err != nil {
-			return err
+// ローカルへのリクエストが期限までに終わらなかったときにフロントに返すレスポンス
+func gatewayTimeout(reqWrapper *remote.HttpRequestWrapper) *remote.HttpResponseWrapper {
+	return &remote.HttpResponseWrapper{
+		ConnectionId: reqWrapper.ConnectionId,
+		Status:       http.StatusGatewayTimeout,
+	}
+}
+
+// supervise は接続が切れるたびにバックオフを挟んで再接続する
+// 再接続するときは奪われたドメインを除いて登録し直し、全て奪われたら止める
+func supervise(client remote.ProxyClient) {
+	b := newBackoff(defaultConfig.ReconnectMinInterval, defaultConfig.ReconnectMaxInterval)
+	connect := connectTunnel
+	for {
+		connectionOpts := connection()
+		if connectionOpts == nil {
+			// 再接続すると奪い返してしまうので止める
+			log.Error().Err(errDisplaced).Msg("stop reconnecting")
+			return
 		}
-		if err := sendStream.Send(respWrapper); err != nil {
-			return err
+		log.Info().Str("state", "connecting").Str("domain", connectionOpts.Domain).Msg("")
+		err := connect(client, connectionOpts, func() {
+			b.Reset()
+			log.Info().Str("state", "connected").Str("domain", connectionOpts.Domain).Msg("")
+		})
+		// BackendReceive/BackendSendではTCPやUDPを流せないのでHTTPの場合だけ切り替える
+		// 1つのストリームで登録できるのは1つのドメインだけなので複数の場合も切り替えない
+		if status.Code(err) == codes.Unimplemented && connectionOpts.Protocol == remote.Protocol_PROTOCOL_HTTP && len(connectionOpts.Domains) == 1 {
+			log.Warn().Err(err).Msg("relay server does not support Tunnel. fall back to BackendReceive/BackendSend")
+			connect = connectLegacy
+			continue
 		}
+		wait := b.Next()
+		log.Warn().
+			Err(err).
+			Str("state", "disconnected").
+			Str("domain", connectionOpts.Domain).
+			Dur("retry_after", wait).
+			Msg("")
+		time.Sleep(wait)
 	}
 }
 
 func main() {
+	rand.Seed(time.Now().UnixNano())
 	log.Logger = log.With().Caller().Logger()
 	log.Info().Msg("start")
-	conn, err := grpc.Dial(defaultConfig.RelayServerConfig.Addr(), grpc.WithInsecure(), grpc.WithBlock())
+	opts := []grpc.DialOption{grpc.WithInsecure(), grpc.WithBlock()}
+	if defaultConfig.Token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken(defaultConfig.Token)))
+		log.Warn().Msg("RELAY_TOKEN is sent in plaintext. connect to the relay through a TLS tunnel or a private network")
+	}
+	conn, err := grpc.Dial(defaultConfig.RelayServerConfig.Addr(), opts...)
 	if err != nil {
 		log.Fatal().Err(err).Msg("")
 	}
@@ -120,10 +172,25 @@ func main() {
 
 	client := remote.NewProxyClient(conn)
 
-	if err := connect(client, &remote.Connection{
-		Domain:        defaultConfig.BackendHostName,
-		DeveloperName: defaultConfig.DeveloperName,
-	}); err != nil {
+	upstreams, err = loadUpstreams()
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	routes, err = loadRoutes()
+	if err != nil {
 		log.Fatal().Err(err).Msg("")
 	}
+	for _, u := range upstreams {
+		for _, r := range routes[u.Domain] {
+			log.Info().
+				Str("domain", r.Domain).
+				Str("path_prefix", r.PathPrefix).
+				Str("header", r.HeaderName).
+				Bool("strip_prefix", r.StripPrefix).
+				Str("upstream", r.Scheme+"://"+r.Host).
+				Msg("route")
+		}
+	}
+
+	supervise(client)
 }
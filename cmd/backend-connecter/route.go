@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/config"
+)
+
+// routes はROUTESから読んだ振り分け先. 登録するドメインごとに優先する順に並べる
+var routes map[string][]config.Route
+
+// loadRoutes はROUTESを読み、ドメインごとに長いパスのもの、同じパスの中ではヘッダーを見るものを先に並べる
+// 優先度が同じものは書いた順にする. ドメインを書かないものはBACKEND_HOST_NAMEに当てはめる
+func loadRoutes() (map[string][]config.Route, error) {
+	rs, err := config.ParseRoutes(defaultConfig.Routes, defaultConfig.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	byDomain := make(map[string][]config.Route)
+	for _, r := range rs {
+		if r.Domain == "" {
+			// DOMAINSのどのドメインに当てはめるか決められない
+			if defaultConfig.Domains != "" {
+				return nil, fmt.Errorf("route %s must specify a domain when DOMAINS is set", r.PathPrefix)
+			}
+			r.Domain = defaultConfig.BackendHostName
+		}
+		if !hasUpstream(r.Domain) {
+			return nil, fmt.Errorf("route %s%s is for an unregistered domain", r.Domain, r.PathPrefix)
+		}
+		byDomain[r.Domain] = append(byDomain[r.Domain], r)
+	}
+	for _, rs := range byDomain {
+		sort.SliceStable(rs, func(i, j int) bool {
+			if len(rs[i].PathPrefix) != len(rs[j].PathPrefix) {
+				return len(rs[i].PathPrefix) > len(rs[j].PathPrefix)
+			}
+			return rs[i].HeaderName != "" && rs[j].HeaderName == ""
+		})
+	}
+	return byDomain, nil
+}
+
+// hasUpstream はdomainが登録するドメインかを返す
+func hasUpstream(domain string) bool {
+	for _, u := range upstreams {
+		if u.Domain == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// selectRoute はリクエストを送るローカルのバックエンドを選ぶ
+// ドメインの振り分け先のどれにも当てはまらない場合はドメインに対応するupstreamに送る
+func selectRoute(upstream config.DomainUpstream, path string, header http.Header) config.Route {
+	for _, r := range routes[upstream.Domain] {
+		if !hasPathPrefix(path, r.PathPrefix) {
+			continue
+		}
+		if r.HeaderName != "" && header.Get(r.HeaderName) != r.HeaderValue {
+			continue
+		}
+		return r
+	}
+	return config.Route{
+		Domain:     upstream.Domain,
+		PathPrefix: "/",
+		Scheme:     upstream.Scheme,
+		Host:       upstream.Host,
+	}
+}
+
+// hasPathPrefix はpathがprefixのパスかその下のパスかを返す. /apiは/apixには当てはまらない
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// stripPathPrefix はpathからprefixを除く. 空になる場合は/にする
+func stripPathPrefix(path, prefix string) string {
+	path = strings.TrimPrefix(path, strings.TrimSuffix(prefix, "/"))
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
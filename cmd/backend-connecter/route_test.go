@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/config"
+)
+
+func TestHasPathPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		want   bool
+	}{
+		{path: "/api", prefix: "/api", want: true},
+		{path: "/api/users", prefix: "/api", want: true},
+		{path: "/apix", prefix: "/api", want: false},
+		{path: "/ap", prefix: "/api", want: false},
+		{path: "/api", prefix: "/api/", want: false},
+		{path: "/api/", prefix: "/api/", want: true},
+		{path: "/api/users", prefix: "/api/", want: true},
+		{path: "/", prefix: "/", want: true},
+		{path: "/anything", prefix: "/", want: true},
+	}
+	for _, tt := range tests {
+		if got := hasPathPrefix(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("hasPathPrefix(%q, %q) = %v, want %v", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestStripPathPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		want   string
+	}{
+		{path: "/api/users", prefix: "/api", want: "/users"},
+		{path: "/api", prefix: "/api", want: "/"},
+		{path: "/api/", prefix: "/api", want: "/"},
+		{path: "/api/users", prefix: "/api/", want: "/users"},
+		{path: "/users", prefix: "/", want: "/users"},
+	}
+	for _, tt := range tests {
+		if got := stripPathPrefix(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("stripPathPrefix(%q, %q) = %q, want %q", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestSelectRoute(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes string
+		// 空の場合はalice.test
+		domain string
+		path   string
+		header http.Header
+		want   string
+	}{
+		{
+			name:   "fallback to domain upstream",
+			routes: "/api=localhost:3000",
+			path:   "/web",
+			want:   "localhost:8080",
+		},
+		{
+			name:   "prefix does not match partial segment",
+			routes: "/api=localhost:3000",
+			path:   "/apix",
+			want:   "localhost:8080",
+		},
+		{
+			name:   "longest prefix wins regardless of order",
+			routes: "/=localhost:3000,/api=localhost:3001,/api/v2=localhost:3002",
+			path:   "/api/v2/users",
+			want:   "localhost:3002",
+		},
+		{
+			name:   "header route before plain route with same prefix",
+			routes: "/api=localhost:3001,/api=localhost:3002;header=X-Version:2",
+			path:   "/api/users",
+			header: http.Header{"X-Version": {"2"}},
+			want:   "localhost:3002",
+		},
+		{
+			name:   "header mismatch falls through",
+			routes: "/api=localhost:3001,/api=localhost:3002;header=X-Version:2",
+			path:   "/api/users",
+			header: http.Header{"X-Version": {"1"}},
+			want:   "localhost:3001",
+		},
+		{
+			name:   "longer prefix wins over header route",
+			routes: "/api=localhost:3001;header=X-Version:2,/api/v2=localhost:3002",
+			path:   "/api/v2",
+			header: http.Header{"X-Version": {"2"}},
+			want:   "localhost:3002",
+		},
+		{
+			name:   "first written wins on tie",
+			routes: "/api=localhost:3001,/api=localhost:3002",
+			path:   "/api",
+			want:   "localhost:3001",
+		},
+		{
+			name:   "route without domain is for backend host name",
+			routes: "/api=localhost:3001",
+			domain: "bob.test",
+			path:   "/api",
+			want:   "localhost:9090",
+		},
+		{
+			name:   "route for another domain is ignored",
+			routes: "bob.test/api=localhost:3003",
+			path:   "/api",
+			want:   "localhost:8080",
+		},
+		{
+			name:   "route for the requested domain",
+			routes: "alice.test/api=localhost:3001,bob.test/api=localhost:3003",
+			domain: "bob.test",
+			path:   "/api",
+			want:   "localhost:3003",
+		},
+	}
+
+	defer func(c config.BackendConnecterConfig, u []config.DomainUpstream) {
+		*defaultConfig, upstreams = c, u
+		routes = nil
+	}(*defaultConfig, upstreams)
+	defaultConfig.BackendHostName = "alice.test"
+	defaultConfig.Domains = ""
+	upstreams = []config.DomainUpstream{
+		{Domain: "alice.test", Scheme: "http", Host: "localhost:8080"},
+		{Domain: "bob.test", Scheme: "http", Host: "localhost:9090"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultConfig.Routes = tt.routes
+			rs, err := loadRoutes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			routes = rs
+			upstream := upstreams[0]
+			if tt.domain == "bob.test" {
+				upstream = upstreams[1]
+			}
+			if got := selectRoute(upstream, tt.path, tt.header).Host; got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRoutesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains string
+		routes  string
+	}{
+		{name: "malformed", routes: "/api"},
+		{name: "unregistered domain", routes: "carol.test/api=localhost:3001"},
+		{name: "no domain with DOMAINS", domains: "alice.test=localhost:8080", routes: "/api=localhost:3001"},
+	}
+
+	defer func(c config.BackendConnecterConfig, u []config.DomainUpstream) {
+		*defaultConfig, upstreams = c, u
+	}(*defaultConfig, upstreams)
+	defaultConfig.BackendHostName = "alice.test"
+	upstreams = []config.DomainUpstream{{Domain: "alice.test", Scheme: "http", Host: "localhost:8080"}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultConfig.Domains = tt.domains
+			defaultConfig.Routes = tt.routes
+			if _, err := loadRoutes(); err == nil {
+				t.Error("loadRoutes does not fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"io"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+)
+
+// responseSender は1本のBackendSendのストリームで全てのレスポンスを返す
+// 送信待ちのレスポンスがqueueの大きさを超えるとworkerを待たせる
+type responseSender struct {
+	stream remote.Proxy_BackendSendClient
+	queue  chan *remote.HttpResponseWrapper
+	done   chan struct{}
+	err    error
+}
+
+func newResponseSender(stream remote.Proxy_BackendSendClient, queueSize int) *responseSender {
+	s := &responseSender{
+		stream: stream,
+		queue:  make(chan *remote.HttpResponseWrapper, queueSize),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *responseSender) run() {
+	defer close(s.done)
+	for respWrapper := range s.queue {
+		if err := s.stream.Send(respWrapper); err != nil {
+			s.err = err
+			return
+		}
+	}
+	if _, err := s.stream.CloseAndRecv(); err != nil && err != io.EOF {
+		s.err = err
+	}
+}
+
+// WriteResponse はレスポンスを送信待ちに積む
+func (s *responseSender) WriteResponse(respWrapper *remote.HttpResponseWrapper) error {
+	select {
+	case s.queue <- respWrapper:
+		return nil
+	case <-s.done:
+		return s.err
+	}
+}
+
+// WriteBody はBackendSendではボディを分けて送れないのでエラーを返す
+// relayはBackendReceiveで接続したバックエンドにボディを分けるリクエストを送らない
+func (s *responseSender) WriteBody(*remote.BodyChunk) error {
+	return errors.New("BackendSend does not support streaming body")
+}
+
+// Close は送信待ちのレスポンスを送り切ってからストリームを閉じる
+// Sendを呼んでいるworkerが全て終わってから呼ぶこと
+func (s *responseSender) Close() error {
+	close(s.queue)
+	<-s.done
+	return s.err
+}
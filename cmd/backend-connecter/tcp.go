@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+)
+
+// handleTCP はローカルのバックエンドにTCPでつなぎ、両方向のバイト列をBodyChunkでそのまま流す
+// つながったらステータス200のResponseを返し、つなげなければ502を返す
+func handleTCP(ctx context.Context, r request, w responseWriter) error {
+	id := r.wrapper.GetConnectionId()
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", r.upstream.Host)
+	if err != nil {
+		log.Error().Err(err).Str("connection_id", id).Msg("failed to dial backend")
+		return w.WriteResponse(badGateway(r.wrapper))
+	}
+	conn := &upgradedConn{Conn: c}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	if err := w.WriteResponse(&remote.HttpResponseWrapper{
+		ConnectionId: id,
+		Status:       http.StatusOK,
+		StreamBody:   true,
+	}); err != nil {
+		return err
+	}
+
+	log.Debug().Str("connection_id", id).Str("addr", c.RemoteAddr().String()).Msg("tcp connection is established")
+	go func() {
+		if r.body == nil {
+			conn.Close()
+			return
+		}
+		if _, err := io.Copy(conn, r.body); err != nil {
+			conn.Close()
+			return
+		}
+		// 相手が送り終えた後もバックエンドからの返事は読めるように書き込み側だけ閉じる
+		if cw, ok := c.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+			return
+		}
+		conn.Close()
+	}()
+	return streamBody(ctx, id, &upgradedReader{r: conn, conn: conn}, w)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+)
+
+// bearerToken は全てのRPCのメタデータにトークンを付ける
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{
+		remote.MetadataAuthorization: "Bearer " + string(t),
+	}, nil
+}
+
+// relayとの間はTLSを使っていないのでトークンも平文で送る
+func (t bearerToken) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errHeartbeatTimeout = errors.New("heartbeat timeout")
+	// 別のバックエンドにドメインを奪われた
+	errDisplaced = errors.New("domain is taken over by another backend")
+)
+
+func controlFrame(c *remote.Control) *remote.TunnelFrame {
+	return &remote.TunnelFrame{
+		Frame: &remote.TunnelFrame_Control{Control: c},
+	}
+}
+
+func heartbeatFrame() *remote.TunnelFrame {
+	return &remote.TunnelFrame{
+		Frame: &remote.TunnelFrame_Heartbeat{
+			Heartbeat: &remote.Heartbeat{Timestamp: time.Now().UnixNano()},
+		},
+	}
+}
+
+// inFlightRequest は処理中のリクエスト1つを表す
+type inFlightRequest struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// StreamBodyの場合にrelayから届くボディ
+	body *bodyBuffer
+	// relayが受け取っていないレスポンスのBodyChunkの数だけ埋まる. relayが流量を制御しない場合はnil
+	window chan struct{}
+	// UDPのセッション
+	datagram bool
+}
+
+// inFlight は処理中のリクエストを取り消せるように保持する
+type inFlight struct {
+	mu       sync.Mutex
+	requests map[string]*inFlightRequest
+	// relayがCONTROL_WINDOWを送ってくる
+	flowControl bool
+	// relayがCONTROL_WINDOWを待ってリクエストのボディを送る
+	requestFlowControl bool
+	// relayにCONTROL_WINDOWやCONTROL_CANCELを送る
+	send func(*remote.TunnelFrame) error
+}
+
+func newInFlight(registered *remote.Control, send func(*remote.TunnelFrame) error) *inFlight {
+	return &inFlight{
+		requests:           map[string]*inFlightRequest{},
+		flowControl:        registered.GetFlowControl(),
+		requestFlowControl: registered.GetRequestFlowControl(),
+		send:               send,
+	}
+}
+
+// add はリクエストを処理中にする
+// StreamBodyの場合は後から届くボディを読むio.Readerも返す
+func (f *inFlight) add(ctx context.Context, reqWrapper *remote.HttpRequestWrapper) (context.Context, io.Reader) {
+	ctx, cancel := context.WithCancel(ctx)
+	id := reqWrapper.GetConnectionId()
+	r := &inFlightRequest{
+		ctx:      ctx,
+		cancel:   cancel,
+		datagram: reqWrapper.GetProtocol() == remote.Protocol_PROTOCOL_UDP,
+	}
+	if f.flowControl {
+		r.window = make(chan struct{}, remote.BodyWindow)
+	}
+
+	var body io.Reader
+	if reqWrapper.GetStreamBody() {
+		var grant func(n int)
+		if f.requestFlowControl {
+			grant = func(n int) {
+				// 送れないのはトンネルが切れたときで、リクエストも取り消される
+				f.send(controlFrame(&remote.Control{
+					Type:         remote.ControlType_CONTROL_WINDOW,
+					ConnectionId: id,
+					Window:       int32(n),
+				}))
+			}
+		}
+		r.body = newBodyBuffer(ctx, grant)
+		body = r.body
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests[id] = r
+	return ctx, body
+}
+
+// writeBody はrelayから届いたボディをリクエストに渡す
+// relayが流量を制御している場合は待たない. 溜めきれなければリクエストを取り消し、UDPの場合はデータグラムを捨てる
+func (f *inFlight) writeBody(chunk *remote.BodyChunk) {
+	id := chunk.GetConnectionId()
+	f.mu.Lock()
+	r, ok := f.requests[id]
+	f.mu.Unlock()
+	if !ok || r.body == nil {
+		return
+	}
+	if chunk.GetError() != "" {
+		r.body.close(errors.New(chunk.GetError()))
+		return
+	}
+	if r.body.push(chunk.GetData(), chunk.GetEof()) {
+		return
+	}
+	if r.datagram {
+		log.Debug().Str("connection_id", id).Msg("drop datagram")
+		return
+	}
+	log.Warn().Str("connection_id", id).Msg("request body overflows")
+	f.cancel(id)
+	// relayからの受信を止めないように待たずに送る
+	go f.send(controlFrame(&remote.Control{
+		Type:         remote.ControlType_CONTROL_CANCEL,
+		ConnectionId: id,
+		Message:      errBodyOverflow.Error(),
+	}))
+}
+
+// acquire はレスポンスのBodyChunkを1つ送る前に呼び、relayが受け取れるようになるまで待つ
+// リクエストが取り消されていて送る必要がない場合はfalseを返す
+func (f *inFlight) acquire(id string) bool {
+	f.mu.Lock()
+	r, ok := f.requests[id]
+	f.mu.Unlock()
+	if !ok || r.window == nil {
+		return true
+	}
+	select {
+	case r.window <- struct{}{}:
+		return true
+	case <-r.ctx.Done():
+		return false
+	}
+}
+
+// grant はrelayがレスポンスのBodyChunkをn個受け取ったので、その分だけ続きを送れるようにする
+func (f *inFlight) grant(id string, n int) {
+	f.mu.Lock()
+	r, ok := f.requests[id]
+	f.mu.Unlock()
+	if !ok || r.window == nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.window:
+		default:
+			return
+		}
+	}
+}
+
+// cancelAll は全てのリクエストを取り消す
+func (f *inFlight) cancelAll() {
+	f.mu.Lock()
+	ids := make([]string, 0, len(f.requests))
+	for id := range f.requests {
+		ids = append(ids, id)
+	}
+	f.mu.Unlock()
+	for _, id := range ids {
+		f.cancel(id)
+	}
+}
+
+// cancel はリクエストを取り消す. 処理が終わったときにも呼ぶ
+func (f *inFlight) cancel(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r, ok := f.requests[id]; ok {
+		r.cancel()
+		if r.body != nil {
+			r.body.close(errBodyClosed)
+		}
+		delete(f.requests, id)
+	}
+}
+
+// tunnelWriter はレスポンスをTunnelで返す
+type tunnelWriter struct {
+	send     func(*remote.TunnelFrame) error
+	requests *inFlight
+}
+
+func (w *tunnelWriter) WriteResponse(respWrapper *remote.HttpResponseWrapper) error {
+	return w.send(&remote.TunnelFrame{
+		Frame: &remote.TunnelFrame_Response{Response: respWrapper},
+	})
+}
+
+func (w *tunnelWriter) WriteBody(chunk *remote.BodyChunk) error {
+	if !w.requests.acquire(chunk.GetConnectionId()) {
+		// 取り消されたリクエストのボディは誰も待っていない
+		return nil
+	}
+	return w.send(&remote.TunnelFrame{
+		Frame: &remote.TunnelFrame_Body{Body: chunk},
+	})
+}
+
+// liveness はrelayから最後にフレームが届いた時刻を記録する
+// 流量を制御しない古いrelayの場合、リクエストのボディを渡し終わるのを待っている間は受信できないので期限切れとしない
+type liveness struct {
+	mu       sync.Mutex
+	lastSeen time.Time
+	busy     bool
+}
+
+func (l *liveness) touch() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastSeen = time.Now()
+}
+
+func (l *liveness) setBusy(busy bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.busy = busy
+	l.lastSeen = time.Now()
+}
+
+func (l *liveness) expired(timeout time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.busy && time.Since(l.lastSeen) > timeout
+}
+
+// connectTunnel はTunnelでrelayに接続し、切れるまでリクエストを処理する
+// 接続できた時点でonConnectedが呼ばれる
+func connectTunnel(client remote.ProxyClient, connectionOpts *remote.Connection, onConnected func()) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.Tunnel(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&remote.TunnelFrame{
+		Frame: &remote.TunnelFrame_Register{Register: connectionOpts},
+	}); err != nil {
+		return err
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.GetControl().GetType() != remote.ControlType_CONTROL_REGISTERED {
+		return status.Errorf(codes.FailedPrecondition, "unexpected frame: %v", first)
+	}
+	// relayがサブドメインを割り当てた場合はDomainが設定したものと異なる
+	table := newUpstreamTable(first.GetControl())
+	for _, reg := range registrations(first.GetControl()) {
+		if reg.GetError() != "" {
+			// Drainされているなどで登録できなかったドメインは次に接続するときにまた登録を試す
+			log.Warn().Str("domain", reg.GetDomain()).Str("reason", reg.GetError()).Msg("failed to register")
+			continue
+		}
+		u := table.lookup(reg.GetBackendId())
+		log.Info().
+			Str("backend_id", reg.GetBackendId()).
+			Str("domain", reg.GetDomain()).
+			Str("upstream", u.Scheme+"://"+u.Host).
+			Msg("registered")
+	}
+	onConnected()
+
+	// grpcのストリームは同時にSendできないので送信はgoroutine1つで行う
+	frames := make(chan *remote.TunnelFrame, defaultConfig.Concurrency)
+	sendErr := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case frame := <-frames:
+				if err := stream.Send(frame); err != nil {
+					sendErr <- err
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	send := func(frame *remote.TunnelFrame) error {
+		select {
+		case frames <- frame:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	requests := newInFlight(first.GetControl(), send)
+	pool := newWorkerPool(defaultConfig.Concurrency, &tunnelWriter{send: send, requests: requests}, cancel)
+	defer func() {
+		// 処理中のリクエストを止めてからworkerの終了を待つ
+		cancel()
+		requests.cancelAll()
+		pool.Close()
+	}()
+
+	live := &liveness{lastSeen: time.Now()}
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			frame, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			live.touch()
+
+			switch f := frame.Frame.(type) {
+			case *remote.TunnelFrame_Request:
+				id := f.Request.GetConnectionId()
+				reqCtx, body := requests.add(ctx, f.Request)
+				r := request{
+					ctx:     reqCtx,
+					wrapper: f.Request,
+					body:    body,
+					done: func() {
+						requests.cancel(id)
+					},
+					upstream: table.lookup(f.Request.GetBackendId()),
+				}
+				// websocketやTCP、UDPはいつ終わるか分からないのでworkerを占有させない
+				if f.Request.GetUpgrade() || f.Request.GetProtocol() != remote.Protocol_PROTOCOL_HTTP {
+					pool.Go(r)
+					continue
+				}
+				pool.Queue(r)
+			case *remote.TunnelFrame_Body:
+				live.setBusy(true)
+				requests.writeBody(f.Body)
+				live.setBusy(false)
+			case *remote.TunnelFrame_Heartbeat:
+			case *remote.TunnelFrame_Control:
+				switch f.Control.GetType() {
+				case remote.ControlType_CONTROL_CANCEL:
+					log.Debug().Str("connection_id", f.Control.GetConnectionId()).Msg("request is canceled by relay")
+					requests.cancel(f.Control.GetConnectionId())
+				case remote.ControlType_CONTROL_WINDOW:
+					requests.grant(f.Control.GetConnectionId(), int(f.Control.GetWindow()))
+				case remote.ControlType_CONTROL_CLOSE, remote.ControlType_CONTROL_DISPLACED:
+					id := f.Control.GetBackendId()
+					u := table.lookup(id)
+					isDisplaced := f.Control.GetType() == remote.ControlType_CONTROL_DISPLACED
+					if isDisplaced {
+						markDisplaced(u.Domain)
+					}
+					// BackendIdを入れない古いrelayはトンネルごと閉じる
+					if id != "" && table.remove(id) > 0 {
+						log.Warn().
+							Str("backend_id", id).
+							Str("domain", f.Control.GetDomain()).
+							Str("reason", f.Control.GetMessage()).
+							Msg("unregistered by relay server")
+						continue
+					}
+					if isDisplaced {
+						recvErr <- errDisplaced
+					} else {
+						recvErr <- fmt.Errorf("tunnel is closed by relay server: %s", f.Control.GetMessage())
+					}
+					return
+				}
+			default:
+				log.Warn().Msgf("unexpected frame: %T", f)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(defaultConfig.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// relayはハートビートを送り返すので、3回分何も届かなければ切れたとみなす
+			if live.expired(3 * defaultConfig.HeartbeatInterval) {
+				return errHeartbeatTimeout
+			}
+			if err := send(heartbeatFrame()); err != nil {
+				return err
+			}
+		case err := <-recvErr:
+			if err == io.EOF {
+				return errors.New("stream is closed by relay server")
+			}
+			return err
+		case err := <-sendErr:
+			return err
+		case err := <-pool.Err():
+			return err
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/config"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeConn はfakeRelayが中継しているリクエスト1つ
+type fakeConn struct {
+	responses chan *remote.HttpResponseWrapper
+	bodies    chan *remote.BodyChunk
+	// backend-connecterが受け取っていないリクエストのBodyChunkの数だけ埋まる
+	window   chan struct{}
+	canceled chan struct{}
+}
+
+// fakeRelay はTunnelだけを受け付け、relayと同じようにCONTROL_WINDOWの分だけリクエストのボディを流す
+type fakeRelay struct {
+	remote.UnimplementedProxyServer
+	tunnels chan *fakeTunnel
+}
+
+type fakeTunnel struct {
+	stream remote.Proxy_TunnelServer
+	sendMu sync.Mutex
+
+	mu    sync.Mutex
+	conns map[string]*fakeConn
+}
+
+func (r *fakeRelay) Tunnel(stream remote.Proxy_TunnelServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	con := first.GetRegister()
+	if !con.GetFlowControl() {
+		return status.Error(codes.FailedPrecondition, "flow control is expected")
+	}
+	t := &fakeTunnel{stream: stream, conns: map[string]*fakeConn{}}
+	if err := t.send(controlFrame(&remote.Control{
+		Type:               remote.ControlType_CONTROL_REGISTERED,
+		Domain:             con.GetDomain(),
+		BackendId:          "backend",
+		FlowControl:        true,
+		RequestFlowControl: true,
+		Registrations: []*remote.DomainRegistration{
+			{Domain: con.GetDomain(), Protocol: con.GetProtocol(), BackendId: "backend"},
+		},
+	})); err != nil {
+		return err
+	}
+	r.tunnels <- t
+	return t.receive()
+}
+
+func (t *fakeTunnel) send(frame *remote.TunnelFrame) error {
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+	return t.stream.Send(frame)
+}
+
+func (t *fakeTunnel) conn(id string) *fakeConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.conns[id]
+	if !ok {
+		c = &fakeConn{
+			responses: make(chan *remote.HttpResponseWrapper, 1),
+			bodies:    make(chan *remote.BodyChunk, remote.BodyWindow),
+			window:    make(chan struct{}, remote.BodyWindow),
+			canceled:  make(chan struct{}),
+		}
+		t.conns[id] = c
+	}
+	return c
+}
+
+func (t *fakeTunnel) receive() error {
+	for {
+		frame, err := t.stream.Recv()
+		if err != nil {
+			return err
+		}
+		switch f := frame.Frame.(type) {
+		case *remote.TunnelFrame_Response:
+			t.conn(f.Response.GetConnectionId()).responses <- f.Response
+		case *remote.TunnelFrame_Body:
+			t.conn(f.Body.GetConnectionId()).bodies <- f.Body
+		case *remote.TunnelFrame_Heartbeat:
+			t.send(frame)
+		case *remote.TunnelFrame_Control:
+			c := t.conn(f.Control.GetConnectionId())
+			switch f.Control.GetType() {
+			case remote.ControlType_CONTROL_WINDOW:
+				for i := 0; i < int(f.Control.GetWindow()); i++ {
+					<-c.window
+				}
+			case remote.ControlType_CONTROL_CANCEL:
+				close(c.canceled)
+			}
+		}
+	}
+}
+
+// upload はsizeバイトのボディをPOSTし、送ったボディとbackend-connecterから返ってきたレスポンスを返す
+func (t *fakeTunnel) upload(id string, size int64, seed int64) (sent string, got string, err error) {
+	c := t.conn(id)
+	if err := t.send(&remote.TunnelFrame{Frame: &remote.TunnelFrame_Request{Request: &remote.HttpRequestWrapper{
+		HttpMethod:     http.MethodPost,
+		HttpRequestURL: "http://a.test/upload",
+		ConnectionId:   id,
+		Domain:         "a.test",
+		BackendId:      "backend",
+		StreamBody:     true,
+		Protocol:       remote.Protocol_PROTOCOL_HTTP,
+	}}}); err != nil {
+		return "", "", err
+	}
+
+	h := sha256.New()
+	src := io.LimitReader(rand.New(rand.NewSource(seed)), size)
+	for {
+		data := make([]byte, chunkSize)
+		n, err := io.ReadFull(src, data)
+		if n > 0 {
+			select {
+			case c.window <- struct{}{}:
+			case <-c.canceled:
+				return "", "", fmt.Errorf("%s is canceled", id)
+			}
+			h.Write(data[:n])
+			if err := t.send(&remote.TunnelFrame{Frame: &remote.TunnelFrame_Body{Body: &remote.BodyChunk{
+				ConnectionId: id,
+				Data:         data[:n],
+			}}}); err != nil {
+				return "", "", err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	if err := t.send(&remote.TunnelFrame{Frame: &remote.TunnelFrame_Body{Body: &remote.BodyChunk{
+		ConnectionId: id,
+		Eof:          true,
+	}}}); err != nil {
+		return "", "", err
+	}
+	sent = fmt.Sprintf("%d %x", size, h.Sum(nil))
+
+	var resp *remote.HttpResponseWrapper
+	select {
+	case resp = <-c.responses:
+	case <-c.canceled:
+		return "", "", fmt.Errorf("%s is canceled", id)
+	}
+	if resp.GetStatus() != http.StatusOK || !resp.GetStreamBody() {
+		return "", "", fmt.Errorf("%s: status %d, stream body %v", id, resp.GetStatus(), resp.GetStreamBody())
+	}
+	var body strings.Builder
+	consumed := 0
+	for chunk := range c.bodies {
+		body.Write(chunk.GetData())
+		if chunk.GetEof() {
+			break
+		}
+		consumed++
+		if consumed >= remote.BodyWindow/2 {
+			t.send(controlFrame(&remote.Control{
+				Type:         remote.ControlType_CONTROL_WINDOW,
+				ConnectionId: id,
+				Window:       int32(consumed),
+			}))
+			consumed = 0
+		}
+	}
+	return sent, body.String(), nil
+}
+
+// workerが1つでも、待っているリクエストのボディがトンネルを塞がずに全てのアップロードが終わり、溜めるボディも増えない
+func TestTunnelInterleavedUploads(t *testing.T) {
+	const (
+		uploads = 3
+		size    = 100 << 20
+	)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := sha256.New()
+		n, err := io.Copy(h, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "%d %x", n, h.Sum(nil))
+	}))
+	defer upstream.Close()
+
+	defer func(u []config.DomainUpstream, concurrency int) {
+		upstreams = u
+		defaultConfig.Concurrency = concurrency
+	}(upstreams, defaultConfig.Concurrency)
+	upstreams = []config.DomainUpstream{
+		{Domain: "a.test", Scheme: "http", Host: strings.TrimPrefix(upstream.URL, "http://")},
+	}
+	defaultConfig.Concurrency = 1
+
+	relay := &fakeRelay{tunnels: make(chan *fakeTunnel, 1)}
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	remote.RegisterProxyServer(s, relay)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tunnelErr := make(chan error, 1)
+	go func() {
+		tunnelErr <- connectTunnel(remote.NewProxyClient(conn), connection(), func() {})
+	}()
+	var tunnel *fakeTunnel
+	select {
+	case tunnel = <-relay.tunnels:
+	case err := <-tunnelErr:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel is not connected")
+	}
+
+	// 流量を制御しないと、workerを待っている間に他のアップロードのボディが溜まり続ける
+	var peak uint64
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		var m runtime.MemStats
+		for {
+			select {
+			case <-ticker.C:
+				runtime.ReadMemStats(&m)
+				if m.HeapAlloc > peak {
+					peak = m.HeapAlloc
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sent, got, err := tunnel.upload(fmt.Sprintf("upload-%d", i), size, int64(i))
+			if err == nil && got != sent {
+				err = fmt.Errorf("upload-%d: got %q, want %q", i, got, sent)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-sampled
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if peak > 64<<20 {
+		t.Errorf("peak heap: got %d MB for %d MB of uploads", peak>>20, uploads*size>>20)
+	}
+	select {
+	case err := <-tunnelErr:
+		t.Errorf("tunnel is closed: %v", err)
+	default:
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+)
+
+// UDPのデータグラムの最大の大きさ
+const maxDatagramSize = 65535
+
+// handleUDP はrelayの1つのセッションのデータグラムをローカルのバックエンドにUDPで送り、返ってきたものをそのまま返す
+// UDPIdleTimeoutの間どちらからも届かなければセッションを閉じる
+func handleUDP(ctx context.Context, r request, w responseWriter) error {
+	id := r.wrapper.GetConnectionId()
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "udp", r.upstream.Host)
+	if err != nil {
+		log.Error().Err(err).Str("connection_id", id).Msg("failed to dial backend")
+		return w.WriteResponse(badGateway(r.wrapper))
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer closeOnDone(ctx, c)()
+
+	if err := w.WriteResponse(&remote.HttpResponseWrapper{
+		ConnectionId: id,
+		Status:       http.StatusOK,
+		StreamBody:   true,
+	}); err != nil {
+		return err
+	}
+	log.Debug().Str("connection_id", id).Str("addr", c.RemoteAddr().String()).Msg("udp session is started")
+
+	lastActive := time.Now().UnixNano()
+	touch := func() {
+		atomic.StoreInt64(&lastActive, time.Now().UnixNano())
+	}
+
+	if r.body != nil {
+		go func() {
+			// bodyBufferは1回のReadでBodyChunk1つ分までしか返さないので1回のReadがデータグラム1つになる
+			buf := make([]byte, maxDatagramSize)
+			for {
+				n, err := r.body.Read(buf)
+				if err != nil {
+					cancel()
+					return
+				}
+				touch()
+				if _, err := c.Write(buf[:n]); err != nil {
+					log.Debug().Err(err).Str("connection_id", id).Msg("failed to write datagram")
+				}
+			}
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(defaultConfig.UDPIdleTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if time.Since(time.Unix(0, atomic.LoadInt64(&lastActive))) > defaultConfig.UDPIdleTimeout {
+					log.Debug().Str("connection_id", id).Msg("udp session is expired")
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Debug().Err(err).Str("connection_id", id).Msg("failed to read datagram")
+			}
+			break
+		}
+		touch()
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		if err := w.WriteBody(&remote.BodyChunk{ConnectionId: id, Data: data}); err != nil {
+			return err
+		}
+	}
+	return w.WriteBody(&remote.BodyChunk{ConnectionId: id, Eof: true})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// dialBackend はuのローカルのバックエンドにTCPでつなぐ
+func dialBackend(ctx context.Context, u *url.URL) (net.Conn, error) {
+	host := u.Host
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return conn, nil
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+	if deadline, ok := ctx.Deadline(); ok {
+		tlsConn.SetDeadline(deadline)
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// upgradedConn は自分で閉じたあとの読み込みのエラーをEOFとして扱う
+type upgradedConn struct {
+	net.Conn
+	closed int32
+}
+
+func (c *upgradedConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return c.Conn.Close()
+}
+
+// closeOnDone は取り消されたらバックエンドとのコネクションも閉じる
+// 返した関数を呼ぶと見張るのをやめる
+func closeOnDone(ctx context.Context, conn io.Closer) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+	}
+}
+
+type upgradedReader struct {
+	r    io.Reader
+	conn *upgradedConn
+}
+
+func (r *upgradedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && atomic.LoadInt32(&r.conn.closed) == 1 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// handleUpgrade はwebsocketなどのプロトコルの切り替えをローカルのバックエンドに中継する
+// ハンドシェイクはそのままバックエンドに送り、切り替えた後は両方向のバイト列をBodyChunkで流す
+func handleUpgrade(ctx context.Context, r request, w responseWriter) error {
+	id := r.wrapper.GetConnectionId()
+	req, err := newRequest(ctx, r.upstream, r.wrapper, nil)
+	if err != nil {
+		return w.WriteResponse(badGateway(r.wrapper))
+	}
+	c, err := dialBackend(ctx, req.URL)
+	if err != nil {
+		log.Error().Err(err).Str("connection_id", id).Msg("failed to dial backend")
+		return w.WriteResponse(badGateway(r.wrapper))
+	}
+	conn := &upgradedConn{Conn: c}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	if err := req.Write(conn); err != nil {
+		log.Error().Err(err).Str("connection_id", id).Msg("failed to write handshake request")
+		return w.WriteResponse(badGateway(r.wrapper))
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		log.Error().Err(err).Str("connection_id", id).Msg("failed to read handshake response")
+		return w.WriteResponse(badGateway(r.wrapper))
+	}
+
+	respWrapper := wrapResponse(r.wrapper, resp)
+	respWrapper.StreamBody = true
+	if err := w.WriteResponse(respWrapper); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		return streamBody(ctx, id, resp.Body, w)
+	}
+
+	log.Debug().Str("connection_id", id).Msg("connection is upgraded")
+	go func() {
+		if r.body != nil {
+			io.Copy(conn, r.body)
+		}
+		// ブラウザ側が閉じたらバックエンドとのコネクションも閉じる
+		conn.Close()
+	}()
+	return streamBody(ctx, id, &upgradedReader{r: br, conn: conn}, w)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/config"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+)
+
+// 1つのBodyChunkに入れるボディの最大の大きさ
+const chunkSize = 32 * 1024
+
+type request struct {
+	ctx     context.Context
+	wrapper *remote.HttpRequestWrapper
+	// StreamBodyの場合のリクエストのボディ
+	body io.Reader
+	// 処理が終わったら呼ばれる
+	done func()
+	// リクエストを送るローカルのバックエンド
+	upstream config.DomainUpstream
+}
+
+// responseWriter はレスポンスをrelayに返す
+type responseWriter interface {
+	WriteResponse(*remote.HttpResponseWrapper) error
+	WriteBody(*remote.BodyChunk) error
+}
+
+// handle はリクエストをローカルのバックエンドに流してレスポンスを返す
+// relayにレスポンスを返せなかった場合だけエラーを返す
+// ボディを分けて返す場合はヘッダーを返した後の残りの処理をstreamで返す
+func handle(r request, w responseWriter) (stream func() error, err error) {
+	if r.wrapper.GetProtocol() == remote.Protocol_PROTOCOL_TCP {
+		return nil, handleTCP(r.ctx, r, w)
+	}
+	if r.wrapper.GetProtocol() == remote.Protocol_PROTOCOL_UDP {
+		return nil, handleUDP(r.ctx, r, w)
+	}
+	if r.wrapper.GetUpgrade() {
+		return nil, handleUpgrade(r.ctx, r, w)
+	}
+
+	// フロントの期限までにヘッダーが返ってこなければローカルへのリクエストを止める
+	// event-streamなどのためにボディには期限を付けない
+	// ボディを流し終わるまでローカルへのリクエストを止めないようにcancelはstreamに任せることがある
+	ctx, cancel := context.WithCancel(r.ctx)
+	var timedOut int32
+	stopTimer := func() {}
+	if d := r.wrapper.GetDeadline(); d != 0 {
+		timer := time.AfterFunc(time.Until(time.Unix(0, d)), func() {
+			atomic.StoreInt32(&timedOut, 1)
+			cancel()
+		})
+		stopTimer = func() {
+			timer.Stop()
+		}
+	}
+
+	id := r.wrapper.GetConnectionId()
+	resp, err := doRequest(ctx, r.upstream, r.wrapper, r.body)
+	stopTimer()
+	if r.ctx.Err() == context.Canceled {
+		// 取り消されたリクエストのレスポンスは誰も待っていない
+		cancel()
+		log.Debug().Str("connection_id", id).Msg("request is canceled")
+		return nil, nil
+	}
+	if err != nil {
+		cancel()
+		if atomic.LoadInt32(&timedOut) == 1 {
+			log.Warn().Err(err).Str("connection_id", id).Msg("request timed out")
+			return nil, w.WriteResponse(gatewayTimeout(r.wrapper))
+		}
+		log.Error().Err(err).Str("connection_id", id).Msg("request to backend failed")
+		return nil, w.WriteResponse(badGateway(r.wrapper))
+	}
+
+	respWrapper := wrapResponse(r.wrapper, resp)
+	if !r.wrapper.GetStreamBody() {
+		defer cancel()
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			log.Error().Err(err).Str("connection_id", id).Msg("failed to read response body")
+			return nil, w.WriteResponse(badGateway(r.wrapper))
+		}
+		respWrapper.Body = body
+		return nil, w.WriteResponse(respWrapper)
+	}
+
+	respWrapper.StreamBody = true
+	if err := w.WriteResponse(respWrapper); err != nil {
+		resp.Body.Close()
+		cancel()
+		return nil, err
+	}
+	return func() error {
+		defer cancel()
+		defer resp.Body.Close()
+		return streamBody(r.ctx, id, resp.Body, w)
+	}, nil
+}
+
+// streamBody はレスポンスのボディをBodyChunkに分けて返す
+func streamBody(ctx context.Context, id string, body io.Reader, w responseWriter) error {
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			if err := w.WriteBody(&remote.BodyChunk{ConnectionId: id, Data: data}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return w.WriteBody(&remote.BodyChunk{ConnectionId: id, Eof: true})
+		}
+		if err != nil {
+			if ctx.Err() == context.Canceled {
+				return nil
+			}
+			log.Error().Err(err).Str("connection_id", id).Msg("failed to read response body")
+			return w.WriteBody(&remote.BodyChunk{ConnectionId: id, Eof: true, Error: err.Error()})
+		}
+	}
+}
+
+// worker はリクエストを1つずつ処理する
+// event-streamのようにいつ終わるか分からないボディはヘッダーを返したら別のgoroutineに任せて次のリクエストに移る
+func (p *workerPool) worker() error {
+	for r := range p.requests {
+		stream, err := handle(r, p.writer)
+		if err == nil && stream != nil {
+			p.run(r, stream)
+			continue
+		}
+		if r.done != nil {
+			r.done()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// workerPool は決まった数のworkerでリクエストを並列に処理する
+type workerPool struct {
+	requests chan request
+	errc     chan error
+	writer   responseWriter
+	onError  func()
+	wg       sync.WaitGroup
+
+	// Queueで渡され、workerが空くのを待っているリクエスト
+	mu      sync.Mutex
+	pending []request
+	queued  chan struct{}
+	stop    chan struct{}
+	fed     chan struct{}
+}
+
+// newWorkerPool はworkerを起動する
+// workerがレスポンスを返せなくなった場合はonErrorが呼ばれる
+func newWorkerPool(concurrency int, w responseWriter, onError func()) *workerPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	p := &workerPool{
+		requests: make(chan request),
+		errc:     make(chan error, concurrency),
+		writer:   w,
+		onError:  onError,
+		queued:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		fed:      make(chan struct{}),
+	}
+	go p.feed()
+	for i := 0; i < concurrency; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			if err := p.worker(); err != nil {
+				p.errc <- err
+				onError()
+			}
+		}()
+	}
+	return p
+}
+
+// Go はworkerの数に関係なくリクエストを処理する
+// websocketのように長くつながるリクエストでworkerを埋めないために使う
+func (p *workerPool) Go(r request) {
+	p.run(r, func() error {
+		stream, err := handle(r, p.writer)
+		if err != nil || stream == nil {
+			return err
+		}
+		return stream()
+	})
+}
+
+// run はworkerの外のgoroutineでfを呼び、終わったらリクエストの処理を終える
+func (p *workerPool) run(r request, f func() error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		err := f()
+		if r.done != nil {
+			r.done()
+		}
+		if err != nil {
+			select {
+			case p.errc <- err:
+			default:
+			}
+			p.onError()
+		}
+	}()
+}
+
+// Dispatch は空いているworkerにリクエストを渡す
+// 全てのworkerが処理中の場合は待つ
+func (p *workerPool) Dispatch(r request) error {
+	select {
+	case p.requests <- r:
+		return nil
+	case err := <-p.errc:
+		return err
+	}
+}
+
+// Queue はリクエストを待たずに受け付け、workerが空いたら渡す
+// relayからの受信を止めないように、全てのworkerが処理中でも待たない
+func (p *workerPool) Queue(r request) {
+	p.mu.Lock()
+	p.pending = append(p.pending, r)
+	p.mu.Unlock()
+	select {
+	case p.queued <- struct{}{}:
+	default:
+	}
+}
+
+// feed はQueueで受け付けたリクエストを順にworkerに渡す
+func (p *workerPool) feed() {
+	defer close(p.fed)
+	for {
+		p.mu.Lock()
+		if len(p.pending) == 0 {
+			p.mu.Unlock()
+			select {
+			case <-p.queued:
+				continue
+			case <-p.stop:
+				return
+			}
+		}
+		r := p.pending[0]
+		p.pending[0] = request{}
+		p.pending = p.pending[1:]
+		p.mu.Unlock()
+
+		select {
+		case p.requests <- r:
+		case <-p.stop:
+			p.mu.Lock()
+			p.pending = append([]request{r}, p.pending...)
+			p.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Err はworkerで起きたエラーを返す
+func (p *workerPool) Err() <-chan error {
+	return p.errc
+}
+
+// Close は処理中のリクエストが終わるまで待つ
+// workerに渡していないリクエストは処理せずに終える
+func (p *workerPool) Close() {
+	close(p.stop)
+	<-p.fed
+	for _, r := range p.pending {
+		if r.done != nil {
+			r.done()
+		}
+	}
+	p.pending = nil
+	close(p.requests)
+	p.wg.Wait()
+}
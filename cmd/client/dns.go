@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// 登録されたドメインの応答のTTL. 登録が解除されたらすぐに上流の応答に戻るように短くする
+	dnsTTL = 5
+	// 上流のDNSサーバーの応答を待つ時間
+	dnsUpstreamTimeout = 5 * time.Second
+	// UDPで受けるDNSのメッセージの最大の大きさ
+	maxDNSMessageSize = 4096
+)
+
+// dnsServer はrelayに登録されたドメインのA/AAAAにこのclientのアドレスを返し、それ以外は上流に問い合わせる
+// UDPだけを受ける
+type dnsServer struct {
+	domains  *domainWatcher
+	upstream string
+	ipv4     net.IP
+	ipv6     net.IP
+}
+
+// serveDNS はaddrでDNSのクエリを待ち受ける
+func serveDNS(addr string, s *dnsServer) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+
+	log.Info().Str("addr", addr).Str("upstream", s.upstream).Msg("listen dns")
+	for {
+		buf := make([]byte, maxDNSMessageSize)
+		n, src, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		go s.handle(pc, src, buf[:n])
+	}
+}
+
+func (s *dnsServer) handle(pc net.PacketConn, src net.Addr, query []byte) {
+	var p dnsmessage.Parser
+	header, err := p.Start(query)
+	if err != nil {
+		log.Debug().Err(err).Msg("invalid dns query")
+		return
+	}
+	q, err := p.Question()
+	if err != nil {
+		log.Debug().Err(err).Msg("invalid dns question")
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(q.Name.String(), "."))
+	if !s.domains.HasHost(name) {
+		s.forward(pc, src, query)
+		return
+	}
+
+	resp, err := s.answer(header, q)
+	if err != nil {
+		log.Error().Err(err).Str("name", name).Msg("failed to build dns response")
+		return
+	}
+	log.Debug().Str("name", name).Str("type", q.Type.String()).Msg("answer registered domain")
+	pc.WriteTo(resp, src)
+}
+
+// answer は登録されたドメインへの応答を作る
+// A/AAAA以外の問い合わせには何も返さずに上流の答えが混ざらないようにする
+func (s *dnsServer) answer(header dnsmessage.Header, q dnsmessage.Question) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{
+		ID:                 header.ID,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   header.RecursionDesired,
+		RecursionAvailable: true,
+	})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: dnsTTL}
+	switch {
+	case q.Type == dnsmessage.TypeA && s.ipv4 != nil:
+		var a dnsmessage.AResource
+		copy(a.A[:], s.ipv4.To4())
+		if err := b.AResource(rh, a); err != nil {
+			return nil, err
+		}
+	case q.Type == dnsmessage.TypeAAAA && s.ipv6 != nil:
+		var aaaa dnsmessage.AAAAResource
+		copy(aaaa.AAAA[:], s.ipv6.To16())
+		if err := b.AAAAResource(rh, aaaa); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// forward は問い合わせをそのまま上流に送り、応答をそのまま返す
+func (s *dnsServer) forward(pc net.PacketConn, src net.Addr, query []byte) {
+	conn, err := net.DialTimeout("udp", s.upstream, dnsUpstreamTimeout)
+	if err != nil {
+		log.Warn().Err(err).Str("upstream", s.upstream).Msg("failed to dial upstream dns")
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsUpstreamTimeout))
+
+	if _, err := conn.Write(query); err != nil {
+		log.Warn().Err(err).Str("upstream", s.upstream).Msg("failed to forward dns query")
+		return
+	}
+	buf := make([]byte, maxDNSMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		log.Warn().Err(err).Str("upstream", s.upstream).Msg("failed to read upstream dns response")
+		return
+	}
+	pc.WriteTo(buf[:n], src)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+)
+
+// relayとのWatchDomainsが切れたときにつなぎ直すまでの時間
+const watchRetryInterval = 5 * time.Second
+
+// domainWatcher はrelayに登録されているドメインの一覧を追いかける
+type domainWatcher struct {
+	relay remote.ProxyClient
+
+	mu sync.RWMutex
+	// ポートを除いたホスト名
+	hosts map[string]struct{}
+}
+
+func newDomainWatcher(relay remote.ProxyClient) *domainWatcher {
+	return &domainWatcher{
+		relay: relay,
+		hosts: map[string]struct{}{},
+	}
+}
+
+// hostname はドメインからポートと末尾の.を除き小文字にする
+func hostname(domain string) string {
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		domain = h
+	}
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// Run はrelayとのストリームが切れてもつなぎ直して一覧を更新し続ける
+func (w *domainWatcher) Run() {
+	for {
+		err := w.watch()
+		// relayにつながっていない間はどのドメインにも届かない
+		w.update(nil)
+		log.Warn().Err(err).Dur("retry_after", watchRetryInterval).Msg("domain watch is closed")
+		time.Sleep(watchRetryInterval)
+	}
+}
+
+func (w *domainWatcher) watch() error {
+	stream, err := w.relay.WatchDomains(context.Background(), &remote.Null{})
+	if err != nil {
+		return err
+	}
+	for {
+		list, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		w.update(list.GetDomains())
+	}
+}
+
+func (w *domainWatcher) update(domains []*remote.Connection) {
+	hosts := make(map[string]struct{}, len(domains))
+	for _, d := range domains {
+		hosts[hostname(d.GetDomain())] = struct{}{}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.hosts = hosts
+}
+
+// HasHost はホスト名がrelayに登録されているかを返す. ポートは見ない
+// *.alice.testのようにワイルドカードで登録されたドメインにも当てはめる
+func (w *domainWatcher) HasHost(host string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	host = hostname(host)
+	if _, ok := w.hosts[host]; ok {
+		return true
+	}
+	for i := strings.Index(host, "."); i >= 0; i = nextDot(host, i) {
+		if _, ok := w.hosts["*"+host[i:]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// nextDot はhostのi番目より後ろにある.の位置を返す. ない場合は-1を返す
+func nextDot(host string, i int) int {
+	j := strings.Index(host[i+1:], ".")
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+// Hosts は登録されているドメインのポートを除いたホスト名を並べて返す
+func (w *domainWatcher) Hosts() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	hosts := make([]string, 0, len(w.hosts))
+	for h := range w.hosts {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
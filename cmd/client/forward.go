@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 登録されていないホストに直接つなぐときの期限
+const directDialTimeout = 10 * time.Second
+
+// forwardProxy はブラウザのHTTP_PROXYに設定されたときのリクエストを処理する
+// relayに登録されたホストはトンネルに流し、それ以外は直接つなぐ
+type forwardProxy struct {
+	// トンネルに流すハンドラー
+	tunnel http.Handler
+	relay  remote.ProxyClient
+	direct *httputil.ReverseProxy
+	// CONNECTの中のTLSを終端する証明書. nilの場合はTLSを受けない
+	tlsConfig *tls.Config
+}
+
+func newForwardProxy(tunnel http.Handler, relay remote.ProxyClient, tlsConfig *tls.Config) *forwardProxy {
+	return &forwardProxy{
+		tunnel: tunnel,
+		relay:  relay,
+		direct: &httputil.ReverseProxy{
+			// absolute-URIのリクエストはそのまま転送先になる
+			Director:      func(*http.Request) {},
+			FlushInterval: -1,
+		},
+		tlsConfig: tlsConfig,
+	}
+}
+
+func (p *forwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.connect(w, r)
+		return
+	}
+	// HostからドメインがわかるリクエストはHTTP_PROXYを使っていない
+	if !r.URL.IsAbs() {
+		p.tunnel.ServeHTTP(w, r)
+		return
+	}
+
+	con, err := p.lookup(r.Context(), r.Host)
+	if err != nil {
+		log.Error().Err(err).Str("host", r.Host).Msg("failed to lookup domain")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if con == nil {
+		p.direct.ServeHTTP(w, r)
+		return
+	}
+	r.Host = con.Domain
+	r.Header.Del("Proxy-Connection")
+	r.Header.Del("Proxy-Authorization")
+	p.tunnel.ServeHTTP(w, r)
+}
+
+// lookup はhostがrelayに登録されていれば登録されているバックエンドを返す. 登録されていなければnilを返す
+// ブラウザはデフォルトのポートを省くので、CONNECTの443や80を外した名前でも探す
+func (p *forwardProxy) lookup(ctx context.Context, host string) (*remote.Connection, error) {
+	candidates := []string{host}
+	if h, port, err := net.SplitHostPort(host); err == nil && (port == "80" || port == "443") {
+		candidates = append(candidates, h)
+	}
+	for _, domain := range candidates {
+		con, err := p.relay.LookupDomain(ctx, &remote.Connection{Domain: domain})
+		switch status.Code(err) {
+		case codes.OK:
+			return con, nil
+		case codes.NotFound:
+			continue
+		case codes.Unimplemented:
+			// 古いrelayでは調べられないので全てトンネルに流す
+			return &remote.Connection{Domain: host}, nil
+		default:
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// connect はCONNECTを処理する
+// 登録されたホストの場合はトンネルに流し、それ以外はそのままつなぐ
+func (p *forwardProxy) connect(w http.ResponseWriter, r *http.Request) {
+	con, err := p.lookup(r.Context(), r.Host)
+	if err != nil {
+		log.Error().Err(err).Str("host", r.Host).Msg("failed to lookup domain")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	var target net.Conn
+	if con == nil {
+		target, err = net.DialTimeout("tcp", r.Host, directDialTimeout)
+		if err != nil {
+			log.Warn().Err(err).Str("host", r.Host).Msg("failed to dial")
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijack is not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to hijack connection")
+		return
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		conn.Close()
+		return
+	}
+	c := &bufferedConn{Conn: conn, r: brw.Reader}
+
+	if con == nil {
+		pipe(c, target)
+		return
+	}
+	p.tunnelConn(c, con, r.Host)
+}
+
+// tunnelConn はクライアントとのコネクションをバックエンドの種類に合わせてトンネルに流す
+func (p *forwardProxy) tunnelConn(c *bufferedConn, con *remote.Connection, host string) {
+	switch con.Protocol {
+	case remote.Protocol_PROTOCOL_TCP:
+		proxyTCP(p.relay, c, con.Domain)
+	case remote.Protocol_PROTOCOL_HTTP:
+		p.serveConn(c, host)
+	default:
+		log.Warn().Str("host", host).Str("protocol", con.Protocol.String()).Msg("unsupported protocol")
+		c.Close()
+	}
+}
+
+// pipe は2つのコネクションの間でどちらかが閉じるまでバイト列をそのまま流す
+func pipe(a, b net.Conn) {
+	defer a.Close()
+	defer b.Close()
+	go func() {
+		io.Copy(b, a)
+		b.Close()
+	}()
+	io.Copy(a, b)
+}
+
+// serveConn はCONNECTでつながったコネクションをこのclientのHTTPサーバーとして受ける
+// TLSの場合は設定された証明書で終端する
+func (p *forwardProxy) serveConn(c *bufferedConn, host string) {
+	first, err := c.r.Peek(1)
+	if err != nil {
+		c.Close()
+		return
+	}
+	var conn net.Conn = c
+	// TLSのハンドシェイクはContentType 22(0x16)から始まる
+	if first[0] == 0x16 {
+		if p.tlsConfig == nil {
+			log.Warn().Str("host", host).Msg("ENABLE_TLS is required to tunnel https through CONNECT")
+			c.Close()
+			return
+		}
+		conn = tls.Server(c, p.tlsConfig)
+	}
+	server := &http.Server{Handler: p.tunnel}
+	// Serveはlistenerが閉じると返るが、受け付けたコネクションは閉じるまで処理を続ける
+	server.Serve(&singleConnListener{conn: conn, addr: conn.LocalAddr()})
+}
+
+// bufferedConn はHijackしたときにbufioに読み込まれていた分から読む
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// singleConnListener は1つのコネクションだけを返すnet.Listener
+type singleConnListener struct {
+	conn net.Conn
+	addr net.Addr
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	if l.conn == nil {
+		return nil, io.EOF
+	}
+	c := l.conn
+	l.conn = nil
+	return c, nil
+}
+
+func (l *singleConnListener) Close() error {
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr {
+	return l.addr
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
-	"io/ioutil"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -11,97 +15,306 @@ import (
 	"github.com/ieee0824/virtual-neighbor-proxy/remote"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var defaultConfig = config.NewClientConfig()
 
-func main() {
-	log.Logger = log.With().Caller().Logger()
-	log.Info().Msg("start")
+// 1つのBodyChunkに入れるボディの最大の大きさ
+const chunkSize = 32 * 1024
 
-	r := gin.Default()
+// sendBody はブラウザからのボディをBodyChunkに分けてrelayに送る
+func sendBody(stream remote.Proxy_FrontendStreamClient, connectionID string, body io.Reader) {
+	send := func(chunk *remote.BodyChunk) error {
+		chunk.ConnectionId = connectionID
+		return stream.Send(&remote.FrontendFrame{
+			Frame: &remote.FrontendFrame_Body{Body: chunk},
+		})
+	}
+	defer stream.CloseSend()
 
-	r.Any("*all", func(ctx *gin.Context) {
-		defer ctx.Request.Body.Close()
-		// websocketを無視
-		if h := ctx.Request.Header.Get("Upgrade"); h != "" {
-			ctx.JSON(http.StatusHTTPVersionNotSupported, nil)
-			ctx.Abort()
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			if err := send(&remote.BodyChunk{Data: data}); err != nil {
+				return
+			}
+		}
+		if err == io.EOF {
+			send(&remote.BodyChunk{Eof: true})
 			return
 		}
-		conn, err := grpc.Dial(defaultConfig.RelayServerConfig.Addr(), grpc.WithInsecure())
 		if err != nil {
-			log.Fatal().Err(err).Msg("")
+			log.Warn().Err(err).Str("connection_id", connectionID).Msg("failed to read request body")
+			send(&remote.BodyChunk{Eof: true, Error: err.Error()})
+			return
 		}
+	}
+}
 
-		defer conn.Close()
-		u := ctx.Request.URL
-		u.Host = ctx.Request.Host
-		if defaultConfig.EnableTLS {
-			u.Scheme = "https"
-		} else {
-			u.Scheme = "http"
+// receiveBody はrelayから届くボディをブラウザに書き込む
+func receiveBody(stream remote.Proxy_FrontendStreamClient, w io.Writer) error {
+	for {
+		frame, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		chunk := frame.GetBody()
+		if chunk == nil {
+			return errors.New("body frame is expected")
+		}
+		if chunk.GetError() != "" {
+			return errors.New(chunk.GetError())
+		}
+		if _, err := w.Write(chunk.GetData()); err != nil {
+			return err
+		}
+		// event-streamなどを届いた分だけすぐにブラウザに返す
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if chunk.GetEof() {
+			return nil
 		}
+	}
+}
 
-		body := []byte{}
-		if ctx.Request.Method != http.MethodGet {
-			b, err := ioutil.ReadAll(ctx.Request.Body)
-			if err != nil {
-				log.Fatal().Err(err).Msg("")
-			}
-			body = b
+// receiveResponse はrelayからレスポンスのステータスとヘッダーを受け取る
+// 受け取れなかった場合はブラウザにエラーを返してfalseを返す
+// deadlineが切れてストリームが取り消された場合はタイムアウトとして扱う
+func receiveResponse(ctx *gin.Context, stream remote.Proxy_FrontendStreamClient, connectionID string, deadline *time.Timer) (*remote.HttpResponseWrapper, bool) {
+	first, err := stream.Recv()
+	expired := deadline != nil && !deadline.Stop()
+	if expired || status.Code(err) == codes.DeadlineExceeded {
+		log.Warn().Err(err).Str("connection_id", connectionID).Msg("request timed out")
+		ctx.JSON(http.StatusGatewayTimeout, nil)
+		return nil, false
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("")
+		ctx.JSON(http.StatusInternalServerError, nil)
+		return nil, false
+	}
+	resp := first.GetResponse()
+	if resp == nil {
+		log.Error().Str("connection_id", connectionID).Msg("response frame is expected")
+		ctx.JSON(http.StatusInternalServerError, nil)
+		return nil, false
+	}
+	return resp, true
+}
+
+// writeResponse はレスポンスをブラウザに返す
+func writeResponse(ctx *gin.Context, stream remote.Proxy_FrontendStreamClient, resp *remote.HttpResponseWrapper) {
+	for key, header := range resp.GetHeaders() {
+		for _, v := range header.Value {
+			ctx.Writer.Header().Add(key, v)
 		}
+	}
+
+	ctx.Status(int(resp.GetStatus()))
+	if !resp.GetStreamBody() {
+		ctx.Writer.Write(resp.GetBody())
+		return
+	}
+	if err := receiveBody(stream, ctx.Writer); err != nil {
+		log.Error().Err(err).Str("connection_id", resp.GetConnectionId()).Msg("failed to receive response body")
+	}
+}
+
+func proxy(ctx *gin.Context) {
+	defer ctx.Request.Body.Close()
+	conn, err := grpc.Dial(defaultConfig.RelayServerConfig.Addr(), grpc.WithInsecure())
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	defer conn.Close()
+	u := ctx.Request.URL
+	u.Host = ctx.Request.Host
+	if defaultConfig.EnableTLS {
+		u.Scheme = "https"
+	} else {
+		u.Scheme = "http"
+	}
 
-		connectionID := uuid.New().String()
-		log.Debug().
-			Str("connection_id", connectionID).
-			Str("http_method", ctx.Request.Method).
-			Msg("")
-		client := remote.NewProxyClient(conn)
-		message := &remote.HttpRequestWrapper{
-			HttpMethod:     ctx.Request.Method,
-			HttpRequestURL: u.String(),
-			Body:           body,
-			ConnectionId:   connectionID,
-			Domain:         ctx.Request.Host,
-			Headers:        map[string]*remote.HttpHeader{},
+	// ブラウザとのコネクションを乗っ取った後はそのコネクションが閉じるとRequestのcontextが取り消されてしまうので使わない
+	upgrade := ctx.Request.Header.Get("Upgrade") != ""
+	parent := ctx.Request.Context()
+	if upgrade {
+		parent = context.Background()
+	}
+	reqCtx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	connectionID := uuid.New().String()
+	log.Debug().
+		Str("connection_id", connectionID).
+		Str("http_method", ctx.Request.Method).
+		Bool("upgrade", upgrade).
+		Msg("")
+	client := remote.NewProxyClient(conn)
+	message := &remote.HttpRequestWrapper{
+		HttpMethod:     ctx.Request.Method,
+		HttpRequestURL: u.String(),
+		ConnectionId:   connectionID,
+		Domain:         ctx.Request.Host,
+		Headers:        map[string]*remote.HttpHeader{},
+		StreamBody:     true,
+		Upgrade:        upgrade,
+	}
+	// 期限はレスポンスのヘッダーが返ってくるまでに適用する
+	// event-streamやwebsocketのボディは期限なく流す
+	if !upgrade {
+		message.Deadline = time.Now().Add(defaultConfig.RequestTimeout).UnixNano()
+	}
+
+	for k, vs := range ctx.Request.Header {
+		message.Headers[k] = &remote.HttpHeader{
+			Key:   k,
+			Value: vs,
 		}
 
-		for k, vs := range ctx.Request.Header {
-			message.Headers[k] = &remote.HttpHeader{
-				Key:   k,
-				Value: vs,
+	}
+
+	stream, err := client.FrontendStream(reqCtx)
+	if err != nil {
+		log.Error().Err(err).Msg("")
+		ctx.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+	if err := stream.Send(&remote.FrontendFrame{
+		Frame: &remote.FrontendFrame_Request{Request: message},
+	}); err != nil {
+		log.Error().Err(err).Msg("")
+		ctx.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+
+	if upgrade {
+		proxyUpgrade(ctx, stream, connectionID)
+		return
+	}
+
+	// ハンドラーが返った後にRequest.Bodyを読まないように、送り終わるのを待ってから返る
+	// 先にレスポンスを送りきっておくと、ブラウザはボディの残りを送るのをやめる
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		sendBody(stream, connectionID, ctx.Request.Body)
+	}()
+	defer func() {
+		cancel()
+		ctx.Writer.Flush()
+		<-sent
+	}()
+
+	// relayが応答しない場合もレスポンスのヘッダーが届くまでの期限で504を返す
+	deadline := time.AfterFunc(defaultConfig.RequestTimeout, cancel)
+	resp, ok := receiveResponse(ctx, stream, connectionID, deadline)
+	if !ok {
+		return
+	}
+	writeResponse(ctx, stream, resp)
+}
+
+func main() {
+	log.Logger = log.With().Caller().Logger()
+	log.Info().Msg("start")
+
+	forwards, err := config.ParseForwards(defaultConfig.TCPForwards)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	for _, forward := range forwards {
+		go func(forward config.Forward) {
+			if err := serveTCP(forward); err != nil {
+				log.Fatal().Err(err).Msg("")
 			}
+		}(forward)
+	}
 
-		}
+	udpForwards, err := config.ParseForwards(defaultConfig.UDPForwards)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	for _, forward := range udpForwards {
+		go func(forward config.Forward) {
+			if err := serveUDP(forward); err != nil {
+				log.Fatal().Err(err).Msg("")
+			}
+		}(forward)
+	}
 
-		resp, err := client.FrontendEndpoint(context.TODO(), message)
+	r := gin.Default()
+
+	r.Any("*all", proxy)
+
+	conn, err := grpc.Dial(defaultConfig.RelayServerConfig.Addr(), grpc.WithInsecure())
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	defer conn.Close()
+
+	var tlsConfig *tls.Config
+	if defaultConfig.EnableTLS {
+		cert, err := tls.LoadX509KeyPair(defaultConfig.SslCertFileName, defaultConfig.SslCertKeyFileName)
 		if err != nil {
-			log.Error().Err(err).Msg("")
-			ctx.JSON(http.StatusInternalServerError, nil)
-			return
+			log.Fatal().Err(err).Msg("")
 		}
-
-		for key, header := range resp.GetHeaders() {
-			for _, v := range header.Value {
-				ctx.Header(key, v)
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	// HTTP_PROXYとして使われた場合も同じポートで受ける
+	relay := remote.NewProxyClient(conn)
+	handler := newForwardProxy(r, relay, tlsConfig)
+	if defaultConfig.SocksPort != "" {
+		go func() {
+			if err := serveSOCKS(defaultConfig.SocksAddr(), handler); err != nil {
+				log.Fatal().Err(err).Msg("")
 			}
-		}
-
-		ctx.Status(int(resp.GetStatus()))
-		ctx.Writer.Write(resp.GetBody())
-	})
+		}()
+	}
+	// DNSとPACはrelayに登録されているドメインの一覧を追いかける
+	var domains *domainWatcher
+	if defaultConfig.DNSPort != "" || defaultConfig.PACPort != "" {
+		domains = newDomainWatcher(relay)
+		go domains.Run()
+	}
+	if defaultConfig.DNSPort != "" {
+		go func() {
+			if err := serveDNS(defaultConfig.DNSAddr(), &dnsServer{
+				domains:  domains,
+				upstream: defaultConfig.DNSUpstream,
+				ipv4:     net.ParseIP(defaultConfig.DNSAnswerIPv4),
+				ipv6:     net.ParseIP(defaultConfig.DNSAnswerIPv6),
+			}); err != nil {
+				log.Fatal().Err(err).Msg("")
+			}
+		}()
+	}
+	if defaultConfig.PACPort != "" {
+		go func() {
+			if err := servePAC(defaultConfig.PACAddr(), domains); err != nil {
+				log.Fatal().Err(err).Msg("")
+			}
+		}()
+	}
 
 	if defaultConfig.EnableTLS {
-		if err := r.RunTLS(
+		if err := http.ListenAndServeTLS(
 			defaultConfig.Addr(),
 			defaultConfig.SslCertFileName,
 			defaultConfig.SslCertKeyFileName,
+			handler,
 		); err != nil {
 			log.Fatal().Err(err).Msg("")
 		}
 	} else {
-		if err := r.Run(defaultConfig.Addr()); err != nil {
+		if err := http.ListenAndServe(defaultConfig.Addr(), handler); err != nil {
 			log.Fatal().Err(err).Msg("")
 		}
 	}
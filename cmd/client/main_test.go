@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// echoRelay はFrontendStreamで届いたボディの長さとハッシュをヘッダーで返し、
+// X-Sizeヘッダーの大きさのボディを分割して返す
+type echoRelay struct {
+	remote.UnimplementedProxyServer
+}
+
+func (*echoRelay) FrontendStream(stream remote.Proxy_FrontendStreamServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	request := first.GetRequest()
+	if request == nil {
+		return status.Error(codes.InvalidArgument, "first frame must be request")
+	}
+	size, err := strconv.ParseInt(strings.Join(request.GetHeaders()["X-Size"].GetValue(), ","), 10, 64)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	h := sha256.New()
+	var n int64
+	for {
+		frame, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		chunk := frame.GetBody()
+		if chunk.GetError() != "" {
+			return status.Error(codes.Aborted, chunk.GetError())
+		}
+		h.Write(chunk.GetData())
+		n += int64(len(chunk.GetData()))
+		if chunk.GetEof() {
+			break
+		}
+	}
+
+	if err := stream.Send(&remote.FrontendFrame{Frame: &remote.FrontendFrame_Response{Response: &remote.HttpResponseWrapper{
+		ConnectionId: request.GetConnectionId(),
+		Status:       200,
+		Headers: map[string]*remote.HttpHeader{
+			"X-Received": {Key: "X-Received", Value: []string{fmt.Sprintf("%d %x", n, h.Sum(nil))}},
+		},
+		StreamBody: true,
+	}}}); err != nil {
+		return err
+	}
+	src := io.LimitReader(rand.New(rand.NewSource(size)), size)
+	for {
+		data := make([]byte, chunkSize)
+		n, err := io.ReadFull(src, data)
+		if n > 0 {
+			if err := stream.Send(&remote.FrontendFrame{Frame: &remote.FrontendFrame_Body{Body: &remote.BodyChunk{Data: data[:n]}}}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	return stream.Send(&remote.FrontendFrame{Frame: &remote.FrontendFrame_Body{Body: &remote.BodyChunk{Eof: true}}})
+}
+
+// hashWriter は書き込まれたボディの長さとハッシュを記録する
+type hashWriter struct {
+	hash.Hash
+	n int64
+}
+
+func (w *hashWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return w.Hash.Write(p)
+}
+
+// digest はseedから作るsizeバイトのボディの長さとハッシュを返す
+func digest(seed, size int64) string {
+	h := sha256.New()
+	io.Copy(h, io.LimitReader(rand.New(rand.NewSource(seed)), size))
+	return fmt.Sprintf("%d %x", size, h.Sum(nil))
+}
+
+// 同時に流した大きなボディがsendBodyとreceiveBodyを通って欠けずに届く
+func TestStreamBody(t *testing.T) {
+	const (
+		streams = 2
+		size    = 100 << 20
+	)
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	remote.RegisterProxyServer(s, &echoRelay{})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := remote.NewProxyClient(conn)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, streams)
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			errs <- func() error {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				stream, err := client.FrontendStream(ctx)
+				if err != nil {
+					return err
+				}
+				id := fmt.Sprintf("stream-%d", seed)
+				// 返ってくるボディは送るボディと大きさを変えて別のものにする
+				download := int64(size/2 + seed)
+				if err := stream.Send(&remote.FrontendFrame{Frame: &remote.FrontendFrame_Request{Request: &remote.HttpRequestWrapper{
+					ConnectionId: id,
+					StreamBody:   true,
+					Headers: map[string]*remote.HttpHeader{
+						"X-Size": {Key: "X-Size", Value: []string{strconv.FormatInt(download, 10)}},
+					},
+				}}}); err != nil {
+					return err
+				}
+				go sendBody(stream, id, io.LimitReader(rand.New(rand.NewSource(seed)), size))
+
+				first, err := stream.Recv()
+				if err != nil {
+					return err
+				}
+				if got, want := strings.Join(first.GetResponse().GetHeaders()["X-Received"].GetValue(), ","), digest(seed, size); got != want {
+					return fmt.Errorf("%s: relay received %q, want %q", id, got, want)
+				}
+				w := &hashWriter{Hash: sha256.New()}
+				if err := receiveBody(stream, w); err != nil {
+					return err
+				}
+				if got, want := fmt.Sprintf("%d %x", w.n, w.Sum(nil)), digest(download, download); got != want {
+					return fmt.Errorf("%s: received %q, want %q", id, got, want)
+				}
+				return nil
+			}()
+		}(int64(i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const pacContentType = "application/x-ns-proxy-autoconfig"
+
+// pacFile はhostsだけをproxyに送るproxy auto-configを作る
+func pacFile(hosts []string, proxy string) (string, error) {
+	set := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		set[h] = true
+	}
+	// JSONはそのままJavaScriptのオブジェクトとして読める
+	domains, err := json.Marshal(set)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "var domains = %s;\n", domains)
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	b.WriteString("\tvar h = host.toLowerCase();\n")
+	b.WriteString("\tif (Object.prototype.hasOwnProperty.call(domains, h)) {\n")
+	fmt.Fprintf(&b, "\t\treturn %q;\n", proxy)
+	b.WriteString("\t}\n")
+	// *.alice.testのようなワイルドカードはホスト名の.から後ろで探す
+	b.WriteString("\tfor (var i = h.indexOf(\".\"); i >= 0; i = h.indexOf(\".\", i + 1)) {\n")
+	b.WriteString("\t\tif (Object.prototype.hasOwnProperty.call(domains, \"*\" + h.substring(i))) {\n")
+	fmt.Fprintf(&b, "\t\t\treturn %q;\n", proxy)
+	b.WriteString("\t\t}\n")
+	b.WriteString("\t}\n")
+	b.WriteString("\treturn \"DIRECT\";\n")
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+// pacProxy はPACに書くこのclientのプロキシの指定
+func pacProxy() string {
+	if defaultConfig.EnableTLS {
+		return "HTTPS " + defaultConfig.PACProxyAddr
+	}
+	return "PROXY " + defaultConfig.PACProxyAddr
+}
+
+// servePAC はrelayに今登録されているドメインだけをこのclientに送るPACファイルを返す
+func servePAC(addr string, domains *domainWatcher) error {
+	r := gin.Default()
+	r.GET("/proxy.pac", func(ctx *gin.Context) {
+		pac, err := pacFile(domains.Hosts(), pacProxy())
+		if err != nil {
+			log.Error().Err(err).Msg("failed to generate pac file")
+			ctx.JSON(http.StatusInternalServerError, nil)
+			return
+		}
+		// 登録や解除がすぐに反映されるようにキャッシュさせない
+		ctx.Header("Cache-Control", "no-store")
+		ctx.Data(http.StatusOK, pacContentType, []byte(pac))
+	})
+	log.Info().Str("addr", addr).Msg("serve pac file")
+	return r.Run(addr)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SOCKS5の値. RFC 1928
+const (
+	socksVersion = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodNoAcceptable = 0xff
+
+	socksCommandConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksReplySucceeded           = 0x00
+	socksReplyGeneralFailure      = 0x01
+	socksReplyHostUnreachable     = 0x04
+	socksReplyCommandNotSupported = 0x07
+	socksReplyAddrNotSupported    = 0x08
+)
+
+// socksError はクライアントに返す応答コードを持つエラー
+type socksError struct {
+	reply byte
+	err   error
+}
+
+func (e *socksError) Error() string {
+	return e.err.Error()
+}
+
+// serveSOCKS はSOCKS5で待ち受け、登録されたホストへのコネクションはトンネルに流し、それ以外は直接つなぐ
+func serveSOCKS(addr string, p *forwardProxy) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	log.Info().Str("addr", addr).Msg("listen socks5")
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handleSOCKS(c)
+	}
+}
+
+func (p *forwardProxy) handleSOCKS(conn net.Conn) {
+	c := &bufferedConn{Conn: conn, r: bufio.NewReader(conn)}
+	host, err := socksHandshake(c)
+	if err != nil {
+		log.Warn().Err(err).Str("remote_addr", conn.RemoteAddr().String()).Msg("socks5 handshake failed")
+		var serr *socksError
+		if errors.As(err, &serr) {
+			writeSOCKSReply(c, serr.reply)
+		}
+		c.Close()
+		return
+	}
+
+	con, err := p.lookup(context.Background(), host)
+	if err != nil {
+		log.Error().Err(err).Str("host", host).Msg("failed to lookup domain")
+		writeSOCKSReply(c, socksReplyGeneralFailure)
+		c.Close()
+		return
+	}
+	if con != nil {
+		if err := writeSOCKSReply(c, socksReplySucceeded); err != nil {
+			c.Close()
+			return
+		}
+		p.tunnelConn(c, con, host)
+		return
+	}
+
+	target, err := net.DialTimeout("tcp", host, directDialTimeout)
+	if err != nil {
+		log.Warn().Err(err).Str("host", host).Msg("failed to dial")
+		writeSOCKSReply(c, socksReplyHostUnreachable)
+		c.Close()
+		return
+	}
+	if err := writeSOCKSReply(c, socksReplySucceeded); err != nil {
+		c.Close()
+		target.Close()
+		return
+	}
+	pipe(c, target)
+}
+
+// socksHandshake は認証なしのネゴシエーションとCONNECTのリクエストを読み、つなぐ先のhost:portを返す
+func socksHandshake(c *bufferedConn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported socks version: %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(c.r, methods); err != nil {
+		return "", err
+	}
+	acceptable := false
+	for _, m := range methods {
+		if m == socksMethodNoAuth {
+			acceptable = true
+		}
+	}
+	if !acceptable {
+		c.Write([]byte{socksVersion, socksMethodNoAcceptable})
+		return "", errors.New("no acceptable authentication method")
+	}
+	if _, err := c.Write([]byte{socksVersion, socksMethodNoAuth}); err != nil {
+		return "", err
+	}
+
+	// VER CMD RSV ATYP
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(c.r, request); err != nil {
+		return "", err
+	}
+	if request[1] != socksCommandConnect {
+		return "", &socksError{reply: socksReplyCommandNotSupported, err: fmt.Errorf("unsupported command: %d", request[1])}
+	}
+
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		l := net.IPv4len
+		if request[3] == socksAddrIPv6 {
+			l = net.IPv6len
+		}
+		ip := make([]byte, l)
+		if _, err := io.ReadFull(c.r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socksAddrDomain:
+		l, err := c.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		name := make([]byte, l)
+		if _, err := io.ReadFull(c.r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", &socksError{reply: socksReplyAddrNotSupported, err: fmt.Errorf("unsupported address type: %d", request[3])}
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))), nil
+}
+
+// writeSOCKSReply は応答を返す. つないだ先のアドレスは使われないので0.0.0.0:0を返す
+func writeSOCKSReply(w io.Writer, reply byte) error {
+	_, err := w.Write([]byte{socksVersion, reply, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/ieee0824/virtual-neighbor-proxy/config"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+// serveTCP はforwardのポートで待ち受け、受け付けたコネクションをrelayに流す
+func serveTCP(forward config.Forward) error {
+	ln, err := net.Listen("tcp", forward.Addr())
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	conn, err := grpc.Dial(defaultConfig.RelayServerConfig.Addr(), grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := remote.NewProxyClient(conn)
+
+	log.Info().Str("addr", forward.Addr()).Str("domain", forward.Domain).Msg("listen tcp")
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go proxyTCP(client, c, forward.Domain)
+	}
+}
+
+// proxyTCP は1つのTCPコネクションのバイト列をそのままrelayとやり取りする
+func proxyTCP(client remote.ProxyClient, c net.Conn, domain string) {
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	connectionID := uuid.New().String()
+	logger := log.With().Str("connection_id", connectionID).Str("domain", domain).Logger()
+	logger.Debug().Str("remote_addr", c.RemoteAddr().String()).Msg("accept tcp connection")
+
+	stream, err := client.FrontendStream(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("")
+		return
+	}
+	if err := stream.Send(&remote.FrontendFrame{
+		Frame: &remote.FrontendFrame_Request{Request: &remote.HttpRequestWrapper{
+			ConnectionId: connectionID,
+			Domain:       domain,
+			StreamBody:   true,
+			Protocol:     remote.Protocol_PROTOCOL_TCP,
+		}},
+	}); err != nil {
+		logger.Error().Err(err).Msg("")
+		return
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		logger.Error().Err(err).Msg("")
+		return
+	}
+	if resp := first.GetResponse(); resp.GetStatus() != http.StatusOK {
+		logger.Error().Int32("status", resp.GetStatus()).Msg("failed to connect to backend")
+		return
+	}
+
+	go sendBody(stream, connectionID, c)
+	if err := receiveBody(stream, c); err != nil {
+		logger.Warn().Err(err).Msg("tcp connection is closed")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ieee0824/virtual-neighbor-proxy/config"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+// UDPのデータグラムの最大の大きさ
+const maxDatagramSize = 65535
+
+// serveUDP はforwardのポートで受けたデータグラムをrelayに流す
+// relayとのストリームが切れたらつなぎ直す
+func serveUDP(forward config.Forward) error {
+	pc, err := net.ListenPacket("udp", forward.Addr())
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+
+	conn, err := grpc.Dial(defaultConfig.RelayServerConfig.Addr(), grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := remote.NewProxyClient(conn)
+
+	log.Info().Str("addr", forward.Addr()).Str("domain", forward.Domain).Msg("listen udp")
+	for {
+		err := relayUDP(client, pc, forward.Domain)
+		log.Warn().Err(err).Str("domain", forward.Domain).Msg("udp stream is closed")
+		time.Sleep(time.Second)
+	}
+}
+
+// relayUDP は1つのストリームで全ての送信元のデータグラムをやり取りする
+// 送信元ごとのセッションはrelayが管理する
+func relayUDP(client remote.ProxyClient, pc net.PacketConn, domain string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	connectionID := uuid.New().String()
+	stream, err := client.FrontendStream(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&remote.FrontendFrame{
+		Frame: &remote.FrontendFrame_Request{Request: &remote.HttpRequestWrapper{
+			ConnectionId: connectionID,
+			Domain:       domain,
+			StreamBody:   true,
+			Protocol:     remote.Protocol_PROTOCOL_UDP,
+		}},
+	}); err != nil {
+		return err
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if resp := first.GetResponse(); resp.GetStatus() != http.StatusOK {
+		return errors.New("udp stream is not accepted")
+	}
+
+	pc.SetReadDeadline(time.Time{})
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			frame, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				// ReadFromを止めてストリームが切れたことを伝える
+				pc.SetReadDeadline(time.Now())
+				return
+			}
+			chunk := frame.GetBody()
+			if chunk == nil || len(chunk.Data) == 0 {
+				continue
+			}
+			addr, err := net.ResolveUDPAddr("udp", chunk.SourceAddr)
+			if err != nil {
+				log.Warn().Err(err).Str("source_addr", chunk.SourceAddr).Msg("invalid source address")
+				continue
+			}
+			if _, err := pc.WriteTo(chunk.Data, addr); err != nil {
+				log.Warn().Err(err).Str("source_addr", chunk.SourceAddr).Msg("failed to write datagram")
+			}
+		}
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return <-recvErr
+			}
+			return err
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		if err := stream.Send(&remote.FrontendFrame{
+			Frame: &remote.FrontendFrame_Body{Body: &remote.BodyChunk{
+				ConnectionId: connectionID,
+				SourceAddr:   addr.String(),
+				Data:         data,
+			}},
+		}); err != nil {
+			return <-recvErr
+		}
+	}
+}
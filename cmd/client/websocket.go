@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+)
+
+// proxyUpgrade はwebsocketなどのプロトコルの切り替えを中継する
+// バックエンドが切り替えに応じた後はブラウザとのコネクションをそのままrelayにつなぐ
+func proxyUpgrade(ctx *gin.Context, stream remote.Proxy_FrontendStreamClient, connectionID string) {
+	resp, ok := receiveResponse(ctx, stream, connectionID, nil)
+	if !ok {
+		return
+	}
+	if resp.GetStatus() != http.StatusSwitchingProtocols {
+		// 切り替えなかった場合は普通のレスポンスとして返す
+		stream.Send(&remote.FrontendFrame{
+			Frame: &remote.FrontendFrame_Body{Body: &remote.BodyChunk{
+				ConnectionId: connectionID,
+				Eof:          true,
+			}},
+		})
+		stream.CloseSend()
+		writeResponse(ctx, stream, resp)
+		return
+	}
+
+	conn, brw, err := ctx.Writer.Hijack()
+	if err != nil {
+		log.Error().Err(err).Str("connection_id", connectionID).Msg("failed to hijack connection")
+		ctx.JSON(http.StatusInternalServerError, nil)
+		return
+	}
+	defer conn.Close()
+
+	header := http.Header{}
+	for key, h := range resp.GetHeaders() {
+		for _, v := range h.Value {
+			header.Add(key, v)
+		}
+	}
+	fmt.Fprintf(brw, "HTTP/1.1 %d %s\r\n", resp.GetStatus(), http.StatusText(int(resp.GetStatus())))
+	header.Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		log.Error().Err(err).Str("connection_id", connectionID).Msg("failed to write handshake response")
+		return
+	}
+
+	go sendBody(stream, connectionID, brw.Reader)
+	if err := receiveBody(stream, conn); err != nil {
+		log.Warn().Err(err).Str("connection_id", connectionID).Msg("upgraded connection is closed")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/rs/zerolog/log"
+)
+
+// Drainしたバックエンドの処理中のリクエストの数を見に行く間隔
+const drainPollInterval = 100 * time.Millisecond
+
+// 管理画面のAPIに付けるヘッダー
+// 独自のヘッダーを付けたリクエストはpreflightを通さないと他のサイトから送れないので、CSRFを防げる
+const adminRequestHeader = "X-Vnp-Admin"
+
+type adminBackend struct {
+	ID            string    `json:"id"`
+	Domain        string    `json:"domain"`
+	DeveloperName string    `json:"developer_name"`
+	Protocol      string    `json:"protocol"`
+	ConnectedAt   time.Time `json:"connected_at"`
+	RemoteAddr    string    `json:"remote_addr"`
+	InFlight      int64     `json:"in_flight"`
+	Drained       bool      `json:"drained"`
+	Weight        int32     `json:"weight"`
+}
+
+// drain は処理中のリクエストが終わるのを待ってからバックエンドの登録を解除する
+// websocketなどが終わらない場合はDrainTimeoutで切る
+func (s *RelayServer) drain(b *registry.Backend) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(defaultConfig.DrainTimeout)
+	defer timeout.Stop()
+
+	for b.InFlight() > 0 {
+		select {
+		case <-ticker.C:
+		case <-timeout.C:
+			log.Warn().Str("domain", b.Domain.String()).Int64("in_flight", b.InFlight()).Msg("drain timed out")
+			s.registry.Disconnect(b, "drain timed out")
+			return
+		case <-b.Done():
+			return
+		}
+	}
+	log.Info().Str("domain", b.Domain.String()).Msg("backend is drained")
+	s.registry.Disconnect(b, "domain is drained")
+}
+
+// requireAdmin は管理画面から送られたリクエストだけを通す
+// tokenが空でない場合はAuthorizationヘッダーにそのトークンを求める
+func requireAdmin(token string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.GetHeader(adminRequestHeader) == "" {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": adminRequestHeader + " header is required"})
+			return
+		}
+		// ブラウザは他のサイトから送るときにOriginを付ける
+		if origin := ctx.GetHeader("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != ctx.Request.Host {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "cross origin request is not allowed"})
+				return
+			}
+		}
+		if token == "" {
+			return
+		}
+		got := strings.TrimPrefix(ctx.GetHeader("Authorization"), bearerPrefix)
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+	}
+}
+
+// newAdminHandler は管理画面とそのJSONのAPIを返す
+// APIはバックエンドのIDも返すので、一覧もrequireAdminを通す
+func newAdminHandler(s *RelayServer, recentErrors *errorLog, token string) http.Handler {
+	r := gin.Default()
+
+	r.GET("/", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(dashboardHTML))
+	})
+
+	api := r.Group("/api", requireAdmin(token))
+	api.GET("/backends", func(ctx *gin.Context) {
+		list := s.backendList()
+		ret := make([]adminBackend, 0, len(list.Backends))
+		for _, b := range list.Backends {
+			ret = append(ret, adminBackend{
+				ID:            b.BackendId,
+				Domain:        b.Domain,
+				DeveloperName: b.DeveloperName,
+				Protocol:      b.Protocol.String(),
+				ConnectedAt:   time.Unix(0, b.ConnectedAt),
+				RemoteAddr:    b.RemoteAddr,
+				InFlight:      b.InFlight,
+				Drained:       b.Drained,
+				Weight:        b.Weight,
+			})
+		}
+		ctx.JSON(http.StatusOK, ret)
+	})
+	api.GET("/drained", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, s.registry.Drained())
+	})
+	api.GET("/errors", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, recentErrors.Recent())
+	})
+
+	// 強制的に切断する. backend-connecterはつなぎ直してくる
+	api.POST("/backends/:id/disconnect", func(ctx *gin.Context) {
+		b, ok := s.registry.Get(ctx.Param("id"))
+		if !ok {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": registry.ErrBackendClosed.Error()})
+			return
+		}
+		log.Info().Str("domain", b.Domain.String()).Str("backend_id", b.ID).Msg("disconnect backend by admin")
+		s.registry.Disconnect(b, "disconnected by admin")
+		ctx.Status(http.StatusNoContent)
+	})
+	// 新しいリクエストと登録を止め、処理中のリクエストが終わったら切断する
+	api.POST("/domains/:domain/drain", func(ctx *gin.Context) {
+		domain := registry.Domain(ctx.Param("domain"))
+		log.Info().Str("domain", domain.String()).Msg("drain domain by admin")
+		for _, b := range s.registry.Drain(domain) {
+			go s.drain(b)
+		}
+		ctx.Status(http.StatusAccepted)
+	})
+	api.POST("/domains/:domain/undrain", func(ctx *gin.Context) {
+		domain := registry.Domain(ctx.Param("domain"))
+		log.Info().Str("domain", domain.String()).Msg("undrain domain by admin")
+		s.registry.Undrain(domain)
+		ctx.Status(http.StatusNoContent)
+	})
+
+	return r
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name    string
+		token   string
+		headers map[string]string
+		want    int
+	}{
+		{name: "no admin header", want: http.StatusForbidden},
+		{name: "admin header", headers: map[string]string{adminRequestHeader: "1"}, want: http.StatusNoContent},
+		{name: "same origin", headers: map[string]string{adminRequestHeader: "1", "Origin": "http://admin.test"}, want: http.StatusNoContent},
+		{name: "cross origin", headers: map[string]string{adminRequestHeader: "1", "Origin": "http://evil.test"}, want: http.StatusForbidden},
+		{name: "token is missing", token: "secret", headers: map[string]string{adminRequestHeader: "1"}, want: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", headers: map[string]string{adminRequestHeader: "1", "Authorization": "Bearer other"}, want: http.StatusUnauthorized},
+		{name: "token", token: "secret", headers: map[string]string{adminRequestHeader: "1", "Authorization": "Bearer secret"}, want: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := registry.New(registry.Options{})
+			r.Drain("web.test")
+			h := newAdminHandler(NewRelayServer(r), newErrorLog(10), tt.token)
+
+			req := httptest.NewRequest(http.MethodPost, "http://admin.test/api/domains/web.test/undrain", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			// 拒否したリクエストではDrainを解除しない
+			drained := len(r.Drained()) != 0
+			if drained != (tt.want != http.StatusNoContent) {
+				t.Fatalf("drained = %v", drained)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const bearerPrefix = "Bearer "
+
+// tokenScope はトークン1つと、そのトークンで登録できる開発者とドメイン
+// DevelopersとDomainsが空の場合は制限しない
+type tokenScope struct {
+	Token      string   `json:"token"`
+	Developers []string `json:"developers"`
+	// *.alice.testのようなワイルドカードを使える. ポートは見ない
+	Domains []string `json:"domains"`
+}
+
+// authenticator はバックエンドの登録をトークンで制限する
+type authenticator struct {
+	scopes []tokenScope
+}
+
+// loadTokens はトークンの一覧をJSONのファイルから読む
+// 例: [{"token": "xxx", "developers": ["alice"], "domains": ["*.alice.test"]}]
+func loadTokens(path string) (*authenticator, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var scopes []tokenScope
+	if err := json.Unmarshal(b, &scopes); err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	for _, s := range scopes {
+		if s.Token == "" {
+			return nil, errors.New("empty token in tokens file")
+		}
+		// 同じトークンに別の範囲を書くとどちらが使われるか分からない
+		if _, ok := seen[s.Token]; ok {
+			return nil, errors.New("duplicate token in tokens file")
+		}
+		seen[s.Token] = struct{}{}
+	}
+	return &authenticator{scopes: scopes}, nil
+}
+
+// authorize はメタデータのトークンでdeveloperNameがdomainsのバックエンドになれるかを確かめる
+// 拒否した場合は理由をログに残す. authenticatorがnilの場合は全て許可する
+func (a *authenticator) authorize(ctx context.Context, developerName string, domains []registry.Domain) error {
+	if a == nil {
+		return nil
+	}
+	err := a.check(ctx, developerName, domains)
+	if err != nil {
+		names := make([]string, 0, len(domains))
+		for _, d := range domains {
+			names = append(names, d.String())
+		}
+		log.Warn().
+			Err(err).
+			Str("developer", developerName).
+			Strs("domains", names).
+			Str("remote_addr", remoteAddr(ctx)).
+			Msg("backend is rejected")
+	}
+	return err
+}
+
+func (a *authenticator) check(ctx context.Context, developerName string, domains []registry.Domain) error {
+	token := bearerToken(ctx)
+	if token == "" {
+		return status.Error(codes.Unauthenticated, "token is required")
+	}
+	scope, ok := a.lookup(token)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if len(scope.Developers) != 0 && !contains(scope.Developers, developerName) {
+		return status.Errorf(codes.PermissionDenied, "developer %q is not allowed", developerName)
+	}
+	for _, d := range domains {
+		if !scope.allows(d) {
+			return status.Errorf(codes.PermissionDenied, "domain %s is not allowed", d)
+		}
+	}
+	return nil
+}
+
+// lookup はトークンの一致するものを探す. 比べる時間から推測されないように全て比べる
+func (a *authenticator) lookup(token string) (tokenScope, bool) {
+	var (
+		found tokenScope
+		ok    bool
+	)
+	for _, s := range a.scopes {
+		if subtle.ConstantTimeCompare([]byte(s.Token), []byte(token)) == 1 && !ok {
+			found, ok = s, true
+		}
+	}
+	return found, ok
+}
+
+func (s tokenScope) allows(domain registry.Domain) bool {
+	if len(s.Domains) == 0 {
+		return true
+	}
+	for _, pattern := range s.Domains {
+		if registry.Match(registry.Domain(pattern), domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken はメタデータからトークンを取り出す
+func bearerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, v := range md.Get(remote.MetadataAuthorization) {
+		if strings.HasPrefix(v, bearerPrefix) {
+			return strings.TrimPrefix(v, bearerPrefix)
+		}
+	}
+	return ""
+}
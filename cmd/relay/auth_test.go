@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// withToken はclientから届いたものとしてトークンをメタデータに入れる
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(remote.MetadataAuthorization, bearerPrefix+token))
+}
+
+func TestLoadTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    int
+		wantErr bool
+	}{
+		{
+			name:    "scoped and unrestricted",
+			content: `[{"token": "a", "developers": ["alice"], "domains": ["*.alice.test"]}, {"token": "b"}]`,
+			want:    2,
+		},
+		{name: "empty list", content: `[]`, want: 0},
+		{name: "not json", content: `token=a`, wantErr: true},
+		{name: "not a list", content: `{"token": "a"}`, wantErr: true},
+		{name: "empty token", content: `[{"token": "", "developers": ["alice"]}]`, wantErr: true},
+		{name: "missing token", content: `[{"developers": ["alice"]}]`, wantErr: true},
+		{name: "duplicate token", content: `[{"token": "a", "developers": ["alice"]}, {"token": "a", "developers": ["bob"]}]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.json")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			a, err := loadTokens(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(a.scopes) != tt.want {
+				t.Errorf("scopes: got %d, want %d", len(a.scopes), tt.want)
+			}
+		})
+	}
+
+	if _, err := loadTokens(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing file is loaded")
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   string
+	}{
+		{name: "no metadata"},
+		{name: "bearer", values: []string{"Bearer abc"}, want: "abc"},
+		{name: "other scheme", values: []string{"Basic abc"}},
+		{name: "no scheme", values: []string{"abc"}},
+		{name: "bearer after other scheme", values: []string{"Basic abc", "Bearer def"}, want: "def"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.values != nil {
+				md := metadata.MD{}
+				md.Append(remote.MetadataAuthorization, tt.values...)
+				ctx = metadata.NewIncomingContext(ctx, md)
+			}
+			if got := bearerToken(ctx); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		domain  registry.Domain
+		want    bool
+	}{
+		{name: "unrestricted", domain: "anything.test", want: true},
+		{name: "exact", domains: []string{"alice.test"}, domain: "alice.test", want: true},
+		{name: "exact ignores port", domains: []string{"alice.test"}, domain: "alice.test:8080", want: true},
+		{name: "exact does not allow subdomain", domains: []string{"alice.test"}, domain: "web.alice.test"},
+		{name: "wildcard allows concrete", domains: []string{"*.alice.test"}, domain: "web.alice.test", want: true},
+		{name: "wildcard allows same wildcard", domains: []string{"*.alice.test"}, domain: "*.alice.test", want: true},
+		{name: "wildcard allows narrower wildcard", domains: []string{"*.alice.test"}, domain: "*.web.alice.test", want: true},
+		{name: "wildcard does not allow broader wildcard", domains: []string{"*.alice.test"}, domain: "*.test"},
+		{name: "wildcard does not allow apex", domains: []string{"*.alice.test"}, domain: "alice.test"},
+		{name: "wildcard does not allow other domain", domains: []string{"*.alice.test"}, domain: "malice.test"},
+		{name: "concrete does not allow wildcard", domains: []string{"web.alice.test"}, domain: "*.alice.test"},
+		{name: "any of several", domains: []string{"alice.test", "*.bob.test"}, domain: "api.bob.test", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tokenScope{Token: "a", Domains: tt.domains}
+			if got := s.allows(tt.domain); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	a := &authenticator{scopes: []tokenScope{
+		{Token: "alice", Developers: []string{"alice"}, Domains: []string{"*.alice.test"}},
+		{Token: "admin"},
+	}}
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		developer string
+		domains   []registry.Domain
+		want      codes.Code
+	}{
+		{name: "no token", ctx: context.Background(), developer: "alice", domains: []registry.Domain{"web.alice.test"}, want: codes.Unauthenticated},
+		{name: "unknown token", ctx: withToken("bob"), developer: "alice", domains: []registry.Domain{"web.alice.test"}, want: codes.Unauthenticated},
+		{name: "allowed", ctx: withToken("alice"), developer: "alice", domains: []registry.Domain{"web.alice.test", "*.alice.test"}, want: codes.OK},
+		{name: "other developer", ctx: withToken("alice"), developer: "bob", domains: []registry.Domain{"web.alice.test"}, want: codes.PermissionDenied},
+		{name: "one domain out of scope", ctx: withToken("alice"), developer: "alice", domains: []registry.Domain{"web.alice.test", "web.bob.test"}, want: codes.PermissionDenied},
+		{name: "unrestricted token", ctx: withToken("admin"), developer: "bob", domains: []registry.Domain{"web.bob.test"}, want: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(a.check(tt.ctx, tt.developer, tt.domains)); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// BackendSendではバックエンドのIDだけでなく、そのバックエンドを登録できるトークンを求める
+func TestBackendSendAuthorization(t *testing.T) {
+	r := registry.New(registry.Options{})
+	relay := NewRelayServer(r)
+	relay.auth = &authenticator{scopes: []tokenScope{
+		{Token: "alice", Developers: []string{"alice"}, Domains: []string{"alice.test"}},
+		{Token: "bob", Developers: []string{"bob"}, Domains: []string{"bob.test"}},
+	}}
+	client := startRelay(t, relay)
+	backend, err := r.Register(registry.BackendOptions{Domain: "alice.test", DeveloperName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		md   []string
+		want codes.Code
+	}{
+		{name: "no backend id", md: []string{remote.MetadataAuthorization, "Bearer alice"}, want: codes.Unauthenticated},
+		{name: "no token", md: []string{remote.MetadataBackendID, backend.ID}, want: codes.Unauthenticated},
+		{name: "token of another developer", md: []string{remote.MetadataBackendID, backend.ID, remote.MetadataAuthorization, "Bearer bob"}, want: codes.PermissionDenied},
+		{name: "unknown backend", md: []string{remote.MetadataBackendID, "unknown", remote.MetadataAuthorization, "Bearer alice"}, want: codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream, err := client.BackendSend(metadata.AppendToOutgoingContext(ctx, tt.md...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := stream.CloseAndRecv(); status.Code(err) != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// 正しいトークンならレスポンスを受け取り、閉じるまで続ける
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.BackendSend(metadata.AppendToOutgoingContext(ctx,
+		remote.MetadataBackendID, backend.ID,
+		remote.MetadataAuthorization, "Bearer alice",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&remote.HttpResponseWrapper{ConnectionId: "unknown", Status: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		t.Errorf("authorized stream: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"google.golang.org/grpc/peer"
+)
+
+// WatchBackendsで処理中のリクエストの数の変化を見に行く間隔
+const backendsRefreshInterval = time.Second
+
+// remoteAddr はgrpcの接続元のアドレスを返す
+func remoteAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return p.Addr.String()
+}
+
+// backendList は登録されているバックエンドの一覧をドメインの順に並べて返す
+func (s *RelayServer) backendList() *remote.BackendList {
+	backends := s.registry.Backends()
+	drained := map[registry.Domain]bool{}
+	for _, d := range s.registry.Drained() {
+		drained[d] = true
+	}
+	list := &remote.BackendList{
+		Backends: make([]*remote.BackendInfo, 0, len(backends)),
+	}
+	for _, b := range backends {
+		list.Backends = append(list.Backends, &remote.BackendInfo{
+			BackendId:     b.ID,
+			Domain:        b.Domain.String(),
+			DeveloperName: b.DeveloperName,
+			Protocol:      b.Protocol,
+			ConnectedAt:   b.ConnectedAt.UnixNano(),
+			RemoteAddr:    b.RemoteAddr,
+			InFlight:      b.InFlight(),
+			Drained:       drained[b.Domain],
+			Weight:        int32(b.Weight),
+		})
+	}
+	sort.Slice(list.Backends, func(i, j int) bool {
+		return list.Backends[i].Domain < list.Backends[j].Domain
+	})
+	return list
+}
+
+// ListBackends は登録されているバックエンドの一覧を返す
+func (s *RelayServer) ListBackends(ctx context.Context, _ *remote.Null) (*remote.BackendList, error) {
+	return s.backendList(), nil
+}
+
+// WatchBackends は登録されているバックエンドの一覧を返し、登録や解除、処理中のリクエストの数が変わるたびに返し直す
+func (s *RelayServer) WatchBackends(_ *remote.Null, stream remote.Proxy_WatchBackendsServer) error {
+	changed, stop := s.registry.Watch()
+	defer stop()
+	ticker := time.NewTicker(backendsRefreshInterval)
+	defer ticker.Stop()
+
+	var last *remote.BackendList
+	for {
+		list := s.backendList()
+		if last == nil || !proto.Equal(list, last) {
+			if err := stream.Send(list); err != nil {
+				return err
+			}
+			last = list
+		}
+
+		select {
+		case <-changed:
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+// dashboardHTML は管理画面. /api を2秒ごとに読み直す
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>relay</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.drained { color: #999; }
+</style>
+</head>
+<body>
+<h1>relay</h1>
+<h2>backends</h2>
+<table>
+<thead><tr><th>domain</th><th>developer</th><th>protocol</th><th>connected at</th><th>remote addr</th><th>in flight</th><th>weight</th><th></th></tr></thead>
+<tbody id="backends"></tbody>
+</table>
+<h2>drained domains</h2>
+<table>
+<thead><tr><th>domain</th><th></th></tr></thead>
+<tbody id="drained"></tbody>
+</table>
+<h2>recent errors</h2>
+<table>
+<thead><tr><th>time</th><th>method</th><th>code</th><th>message</th></tr></thead>
+<tbody id="errors"></tbody>
+</table>
+<script>
+function cell(tr, text) {
+	var td = document.createElement("td");
+	td.textContent = text;
+	tr.appendChild(td);
+	return td;
+}
+
+// ADMIN_TOKENが設定されている場合は聞いたトークンを付けてやり直す
+function api(path, method) {
+	var token = sessionStorage.getItem("adminToken") || "";
+	var headers = {"X-Vnp-Admin": "1"};
+	if (token) {
+		headers["Authorization"] = "Bearer " + token;
+	}
+	return fetch(path, {method: method || "GET", headers: headers}).then(function (r) {
+		if (r.status !== 401) {
+			return r;
+		}
+		// 同時に送った他のリクエストが聞き直していればそのトークンを使う
+		var current = sessionStorage.getItem("adminToken") || "";
+		if (current === token) {
+			current = prompt("admin token") || "";
+			if (!current) {
+				return r;
+			}
+			sessionStorage.setItem("adminToken", current);
+		}
+		return api(path, method);
+	});
+}
+
+function button(td, label, path) {
+	var b = document.createElement("button");
+	b.textContent = label;
+	b.onclick = function () {
+		if (!confirm(label + "?")) {
+			return;
+		}
+		api(path, "POST").then(refresh);
+	};
+	td.appendChild(b);
+}
+
+function fill(id, rows, render) {
+	var tbody = document.getElementById(id);
+	tbody.textContent = "";
+	rows.forEach(function (row) {
+		var tr = document.createElement("tr");
+		render(tr, row);
+		tbody.appendChild(tr);
+	});
+}
+
+function refresh() {
+	api("api/backends").then(function (r) { return r.json(); }).then(function (backends) {
+		fill("backends", backends, function (tr, b) {
+			if (b.drained) {
+				tr.className = "drained";
+			}
+			cell(tr, b.domain);
+			cell(tr, b.developer_name);
+			cell(tr, b.protocol);
+			cell(tr, new Date(b.connected_at).toLocaleString());
+			cell(tr, b.remote_addr);
+			cell(tr, b.in_flight);
+			cell(tr, b.weight);
+			var td = cell(tr, "");
+			button(td, "disconnect", "api/backends/" + encodeURIComponent(b.id) + "/disconnect");
+			if (!b.drained) {
+				button(td, "drain", "api/domains/" + encodeURIComponent(b.domain) + "/drain");
+			}
+		});
+	});
+	api("api/drained").then(function (r) { return r.json(); }).then(function (domains) {
+		fill("drained", domains, function (tr, d) {
+			cell(tr, d);
+			button(cell(tr, ""), "undrain", "api/domains/" + encodeURIComponent(d) + "/undrain");
+		});
+	});
+	api("api/errors").then(function (r) { return r.json(); }).then(function (errors) {
+		fill("errors", errors, function (tr, e) {
+			cell(tr, new Date(e.time).toLocaleString());
+			cell(tr, e.method);
+			cell(tr, e.code);
+			cell(tr, e.message);
+		});
+	});
+}
+
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
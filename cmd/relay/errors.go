@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 管理画面に出す最近のエラーの数
+const recentErrorsSize = 100
+
+type errorEntry struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method"`
+	Code    string    `json:"code"`
+	Message string    `json:"message"`
+}
+
+// errorLog はRPCが返したエラーを新しいものから決まった数だけ覚えておく
+type errorLog struct {
+	mu      sync.Mutex
+	entries []errorEntry
+	next    int
+	size    int
+}
+
+func newErrorLog(size int) *errorLog {
+	return &errorLog{
+		entries: make([]errorEntry, 0, size),
+		size:    size,
+	}
+}
+
+func (l *errorLog) add(method string, err error) {
+	st := status.Convert(err)
+	// クライアントがいなくなっただけのものは残さない
+	if st.Code() == codes.Canceled {
+		return
+	}
+	e := errorEntry{
+		Time:    time.Now(),
+		Method:  method,
+		Code:    st.Code().String(),
+		Message: st.Message(),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) < l.size {
+		l.entries = append(l.entries, e)
+	} else {
+		l.entries[l.next] = e
+	}
+	l.next = (l.next + 1) % l.size
+}
+
+// Recent は覚えているエラーを新しい順に返す
+func (l *errorLog) Recent() []errorEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ret := make([]errorEntry, 0, len(l.entries))
+	for i := 0; i < len(l.entries); i++ {
+		idx := (l.next - 1 - i + len(l.entries)) % len(l.entries)
+		ret = append(ret, l.entries[idx])
+	}
+	return ret
+}
+
+func (l *errorLog) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			l.add(info.FullMethod, err)
+		}
+		return resp, err
+	}
+}
+
+func (l *errorLog) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		if err != nil {
+			l.add(info.FullMethod, err)
+		}
+		return err
+	}
+}
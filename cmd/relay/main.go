@@ -2,97 +2,300 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"sort"
+	"time"
 
 	"github.com/ieee0824/virtual-neighbor-proxy/config"
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
 	"github.com/ieee0824/virtual-neighbor-proxy/remote"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
-type ConnectionID string
-type Domain string
+type RelayServer struct {
+	remote.ProxyServer
+	registry *registry.Registry
+	// nilの場合はトークンなしで登録できる
+	auth *authenticator
+}
 
-func (c ConnectionID) String() string {
-	return string(c)
+func NewRelayServer(r *registry.Registry) *RelayServer {
+	return &RelayServer{
+		registry: r,
+	}
 }
 
-type RequestQueue struct {
-	c chan *remote.HttpRequestWrapper
+// frontからのリクエストを受ける
+// コネクションを作る
+// backendからのリクエストをrequest queue経由でフロントに返す
+func (s *RelayServer) FrontendEndpoint(ctx context.Context, request *remote.HttpRequestWrapper) (*remote.HttpResponseWrapper, error) {
+	connectionID := registry.ConnectionID(request.ConnectionId)
+	conn, err := s.registry.Open(connectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.registry.Close(connectionID)
+
+	backend, ok := s.lookupBackend(request)
+	if !ok {
+		return nil, registry.ErrDomainNotRegistered
+	}
+	defer backend.Begin()()
+
+	if request.Upgrade || request.Protocol != remote.Protocol_PROTOCOL_HTTP {
+		return nil, status.Error(codes.InvalidArgument, "upgrade and tcp are supported only by FrontendStream")
+	}
+	if backend.Protocol != remote.Protocol_PROTOCOL_HTTP {
+		return nil, status.Errorf(codes.FailedPrecondition, "backend does not accept %s", request.Protocol)
+	}
+	// まとめてボディを返すのでバックエンドにも分けずに返してもらう
+	request.StreamBody = false
+	if err := s.send(ctx, backend, request); err != nil {
+		return nil, err
+	}
+	response, err := s.wait(ctx, conn, backend, request.Deadline)
+	if err != nil {
+		return nil, err
+	}
+	stick(request, backend, response)
+	return response, nil
 }
 
-type ResponseQueue struct {
-	c chan *remote.HttpResponseWrapper
+// LookupDomain はDomainに登録されているバックエンドを返す
+func (s *RelayServer) LookupDomain(ctx context.Context, con *remote.Connection) (*remote.Connection, error) {
+	backend, ok := s.registry.Resolve(registry.Domain(con.Domain))
+	if !ok {
+		return nil, status.Error(codes.NotFound, registry.ErrDomainNotRegistered.Error())
+	}
+	domain := backend.Domain.String()
+	// ワイルドカードはホスト名として使えないので問い合わせられたドメインを返す
+	if backend.Domain.Wildcard() {
+		domain = con.Domain
+	}
+	return &remote.Connection{
+		Domain:        domain,
+		DeveloperName: backend.DeveloperName,
+		Protocol:      backend.Protocol,
+	}, nil
 }
 
-var requestQuenes = map[Domain]*RequestQueue{}
-var responseQueues = map[ConnectionID]*ResponseQueue{}
+// WatchDomains は登録されているドメインの一覧を返し、変わるたびに返し直す
+func (s *RelayServer) WatchDomains(_ *remote.Null, stream remote.Proxy_WatchDomainsServer) error {
+	changed, stop := s.registry.Watch()
+	defer stop()
 
-type RelayServer struct {
-	remote.ProxyServer
+	for {
+		backends := s.registry.Backends()
+		list := &remote.DomainList{
+			Domains: make([]*remote.Connection, 0, len(backends)),
+		}
+		for _, b := range backends {
+			// Drainされているドメインには届かない
+			if _, ok := s.registry.Resolve(b.Domain); !ok {
+				continue
+			}
+			list.Domains = append(list.Domains, &remote.Connection{
+				Domain:        b.Domain.String(),
+				DeveloperName: b.DeveloperName,
+				Protocol:      b.Protocol,
+			})
+		}
+		sort.Slice(list.Domains, func(i, j int) bool {
+			return list.Domains[i].Domain < list.Domains[j].Domain
+		})
+		if err := stream.Send(list); err != nil {
+			return err
+		}
+
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
 }
 
-// frontからのリクエストを受ける
-// コネクションを作る
-// backendからのリクエストをrequest queue経由でフロントに返す
-func (s *RelayServer) FrontendEndpoint(ctx context.Context, request *remote.HttpRequestWrapper) (*remote.HttpResponseWrapper, error) {
-	responseQueues[ConnectionID(request.ConnectionId)] = &ResponseQueue{
-		c: make(chan *remote.HttpResponseWrapper),
+// send はクライアントの期限を付けてリクエストをバックエンドに渡す
+func (s *RelayServer) send(ctx context.Context, backend *registry.Backend, request *remote.HttpRequestWrapper) error {
+	// クライアントの期限をバックエンドまで伝える
+	if deadline, ok := ctx.Deadline(); ok {
+		if request.Deadline == 0 || deadline.UnixNano() < request.Deadline {
+			request.Deadline = deadline.UnixNano()
+		}
 	}
 
-	requestQueue, ok := requestQuenes[Domain(request.Domain)]
-	if !ok {
-		return nil, errors.New("Error: request queue doen not exist")
+	// バックエンドが詰まっていても期限を過ぎたら504にする
+	if request.Deadline != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Unix(0, request.Deadline))
+		defer cancel()
 	}
 
-	requestQueue.c <- request
+	if err := backend.Send(ctx, request); err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return err
+	}
+	return nil
+}
 
-	responseQueue := responseQueues[ConnectionID(request.ConnectionId)]
-	response := <-responseQueue.c
-	close(responseQueue.c)
-	delete(responseQueues, ConnectionID(request.ConnectionId))
+// wait はバックエンドからのレスポンスを待つ
+// deadlineまでにレスポンスが届かなかった場合やクライアントがいなくなった場合はバックエンドに取り消しを伝える
+func (s *RelayServer) wait(ctx context.Context, conn *registry.Connection, backend *registry.Backend, deadline int64) (*remote.HttpResponseWrapper, error) {
+	var timeout <-chan time.Time
+	if deadline != 0 {
+		timer := time.NewTimer(time.Until(time.Unix(0, deadline)))
+		defer timer.Stop()
+		timeout = timer.C
+	}
 
-	return response, nil
+	select {
+	case response := <-conn.Responses():
+		return response, nil
+	case <-backend.Done():
+		return nil, registry.ErrBackendClosed
+	case <-conn.Done():
+		// backend-connecterがリクエストのボディを溜めきれずに取り消した
+		return nil, status.Error(codes.ResourceExhausted, "request is canceled by backend")
+	case <-timeout:
+		log.Warn().Str("connection_id", conn.ID.String()).Msg("request timed out")
+		backend.Cancel(conn.ID)
+		return nil, status.Error(codes.DeadlineExceeded, "response is not received before deadline")
+	case <-ctx.Done():
+		log.Warn().Err(ctx.Err()).Str("connection_id", conn.ID.String()).Msg("request is canceled")
+		backend.Cancel(conn.ID)
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
 }
 
 // はじめにNATに穴を開ける
 // フロントからのリクエストをバックエンドに流す
 func (s *RelayServer) BackendReceive(con *remote.Connection, stream remote.Proxy_BackendReceiveServer) error {
-	log.Info().Msgf("%s is connected", con.DeveloperName)
-	requestQueue := &RequestQueue{
-		c: make(chan *remote.HttpRequestWrapper),
+	regs := registrations(con)
+	if len(regs) != 1 {
+		return status.Error(codes.InvalidArgument, "multiple domains are supported only by Tunnel")
+	}
+	domain, err := registerDomain(con.DeveloperName, regs[0], 0)
+	if err != nil {
+		return err
+	}
+	if err := s.auth.authorize(stream.Context(), con.DeveloperName, []registry.Domain{domain}); err != nil {
+		return err
+	}
+	backend, err := s.registry.Register(registry.BackendOptions{
+		Domain:        domain,
+		DeveloperName: con.DeveloperName,
+		RemoteAddr:    remoteAddr(stream.Context()),
+		Weight:        int(con.Weight),
+	})
+	if err != nil {
+		return registerError(err)
 	}
-	requestQuenes[Domain(con.Domain)] = requestQueue
+	defer s.registry.Unregister(backend)
+
+	if err := stream.SendHeader(metadata.Pairs(
+		remote.MetadataBackendID, backend.ID,
+		remote.MetadataDomain, backend.Domain.String(),
+	)); err != nil {
+		return err
+	}
+
 	for {
-		if err := stream.Send(<-requestQueue.c); err != nil {
-			return err
+		select {
+		case request := <-backend.Requests():
+			if err := stream.Send(request); err != nil {
+				return err
+			}
+		case <-backend.Done():
+			if backend.Reason() == registry.ReasonTakenOver {
+				stream.SetTrailer(metadata.Pairs(remote.MetadataDisplaced, backend.Domain.String()))
+				return status.Error(codes.Aborted, backend.Reason())
+			}
+			return nil
+		case <-stream.Context().Done():
+			return stream.Context().Err()
 		}
 	}
 }
 
-// バックエンドからのリクエストを受け取る
+// registerError は登録できなかった理由をgrpcのステータスにする
+func registerError(err error) error {
+	switch err {
+	case registry.ErrDomainTaken:
+		return status.Error(codes.AlreadyExists, err.Error())
+	case registry.ErrDomainDrained:
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return err
+}
+
+// バックエンドからのレスポンスを受け取る
+// 1つのストリームで複数のレスポンスを受け取る
 func (s *RelayServer) BackendSend(stream remote.Proxy_BackendSendServer) error {
-	for {
-		response, err := stream.Recv()
-		if err == io.EOF {
-			return nil
+	// IDを送ってこない古いbackend-connecterでも動くようにする
+	var backendDone <-chan struct{}
+	var backend *registry.Backend
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		if ids := md.Get(remote.MetadataBackendID); len(ids) != 0 {
+			b, ok := s.registry.Get(ids[0])
+			if !ok {
+				return status.Error(codes.NotFound, registry.ErrBackendClosed.Error())
+			}
+			backend = b
+			backendDone = b.Done()
 		}
-		if err != nil {
+	}
+	// 他のバックエンドのIDを騙ってレスポンスを返したり登録を解除したりできないようにする
+	if s.auth != nil {
+		if backend == nil {
+			return status.Error(codes.Unauthenticated, "backend id is required")
+		}
+		if err := s.auth.authorize(stream.Context(), backend.DeveloperName, []registry.Domain{backend.Domain}); err != nil {
 			return err
 		}
+	}
 
-		if _, ok := responseQueues[ConnectionID(response.ConnectionId)]; !ok {
-			return errors.New("response queue does not exist")
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			response, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+
+			// 期限切れなどでフロントが待っていないレスポンスは捨てる
+			if err := s.registry.Deliver(response); err != nil {
+				log.Warn().Err(err).Str("connection_id", response.GetConnectionId()).Msg("drop response")
+			}
 		}
+	}()
 
-		responseQueues[ConnectionID(response.ConnectionId)].c <- response
+	select {
+	case err := <-recvErr:
+		if err == io.EOF {
+			return stream.SendAndClose(&remote.Null{})
+		}
+		// レスポンスを返せなくなったバックエンドにはリクエストを流さない
+		if backend != nil {
+			s.registry.Unregister(backend)
+		}
+		return err
+	case <-backendDone:
+		// BackendReceiveが切れたらこちらも閉じる
+		return nil
 	}
 }
 
-var defaultConfig = config.NewRelayServerConfig()
+var defaultConfig = config.NewRelayConfig()
 
 func main() {
 	log.Logger = log.With().Caller().Logger()
@@ -103,10 +306,50 @@ func main() {
 		log.Fatal().Err(err).Msg("")
 	}
 
-	s := grpc.NewServer()
-	var server RelayServer
+	policy, err := registry.ParsePolicy(defaultConfig.RegistrationPolicy)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	balancer, err := registry.ParseBalancer(defaultConfig.LoadBalancing)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	r := registry.New(registry.Options{
+		Policy:   policy,
+		Balancer: balancer,
+	})
+	r.OnRegister(func(b *registry.Backend) {
+		log.Info().Str("domain", b.Domain.String()).Str("remote_addr", b.RemoteAddr).Msgf("%s is connected", b.DeveloperName)
+	})
+	r.OnUnregister(func(b *registry.Backend) {
+		log.Info().Str("domain", b.Domain.String()).Msgf("%s is disconnected", b.DeveloperName)
+	})
+
+	recentErrors := newErrorLog(recentErrorsSize)
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(recentErrors.UnaryInterceptor()),
+		grpc.StreamInterceptor(recentErrors.StreamInterceptor()),
+	)
+	relay := NewRelayServer(r)
+	if defaultConfig.TokensFile != "" {
+		relay.auth, err = loadTokens(defaultConfig.TokensFile)
+		if err != nil {
+			log.Fatal().Err(err).Str("tokens_file", defaultConfig.TokensFile).Msg("failed to load tokens")
+		}
+		log.Info().Int("tokens", len(relay.auth.scopes)).Msg("backend registration requires token")
+		// relayはTLSで待ち受けないので、トークンは平文で届く
+		log.Warn().Msg("tokens are sent in plaintext. put the relay behind a TLS terminating proxy or a private network")
+	}
+	remote.RegisterProxyServer(s, relay)
 
-	remote.RegisterProxyServer(s, &server)
+	if defaultConfig.AdminPort != "" {
+		go func() {
+			log.Info().Str("addr", defaultConfig.AdminAddr()).Msg("serve admin")
+			if err := http.ListenAndServe(defaultConfig.AdminAddr(), newAdminHandler(relay, recentErrors, defaultConfig.AdminToken)); err != nil {
+				log.Fatal().Err(err).Msg("")
+			}
+		}()
+	}
 	if err := s.Serve(con); err != nil {
 		log.Fatal().Err(err).Msg("")
 	}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestMain(m *testing.M) {
+	// 捨てたボディなどの想定どおりの警告で結果が読みにくくなる
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	os.Exit(m.Run())
+}
+
+// startRelay はrelayをプロセスの中で起動し、つないだクライアントを返す
+func startRelay(t *testing.T, relay *RelayServer) remote.ProxyClient {
+	t.Helper()
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	remote.RegisterProxyServer(s, relay)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return remote.NewProxyClient(conn)
+}
+
+// startLegacyBackend はBackendReceive/BackendSendでつなぎ、リクエストのボディをそのまま返すバックエンドを動かす
+func startLegacyBackend(ctx context.Context, t *testing.T, client remote.ProxyClient, domain string) {
+	t.Helper()
+	stream, err := client.BackendReceive(ctx, &remote.Connection{
+		Domain:        domain,
+		DeveloperName: "alice",
+		Protocol:      remote.Protocol_PROTOCOL_HTTP,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := header.Get(remote.MetadataBackendID)
+	if len(ids) == 0 {
+		t.Fatal("backend id is not returned")
+	}
+	sendStream, err := client.BackendSend(metadata.AppendToOutgoingContext(ctx, remote.MetadataBackendID, ids[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			request, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			if err := sendStream.Send(&remote.HttpResponseWrapper{
+				ConnectionId: request.ConnectionId,
+				Status:       200,
+				Body:         request.Body,
+			}); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// 複数のバックエンドに同時にリクエストを流し、それぞれのレスポンスが取り違えられずに返ることを確かめる
+func TestFrontendEndpointConcurrent(t *testing.T) {
+	r := registry.New(registry.Options{Policy: registry.PolicyPool})
+	client := startRelay(t, NewRelayServer(r))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		startLegacyBackend(ctx, t, client, "alice.test")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				body := fmt.Sprintf("request-%d-%d", i, j)
+				reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				response, err := client.FrontendEndpoint(reqCtx, &remote.HttpRequestWrapper{
+					ConnectionId: uuid.New().String(),
+					Domain:       "alice.test",
+					HttpMethod:   "POST",
+					Body:         []byte(body),
+				})
+				cancel()
+				if err != nil {
+					t.Errorf("%s: %v", body, err)
+					return
+				}
+				if string(response.Body) != body {
+					t.Errorf("got %q, want %q", response.Body, body)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, b := range r.Backends() {
+		if n := b.InFlight(); n != 0 {
+			t.Errorf("backend %s has %d requests in flight", b.ID, n)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	stickyCookie = "cookie"
+	stickyHeader = "header"
+)
+
+// requestHeader はリクエストのヘッダーをhttp.Headerにする
+func requestHeader(request *remote.HttpRequestWrapper) http.Header {
+	h := http.Header{}
+	for _, header := range request.GetHeaders() {
+		for _, v := range header.GetValue() {
+			h.Add(header.GetKey(), v)
+		}
+	}
+	return h
+}
+
+// affinity はリクエストに付いている前回のバックエンドの目印を返す
+func affinity(request *remote.HttpRequestWrapper) string {
+	switch defaultConfig.StickySession {
+	case stickyCookie:
+		req := &http.Request{Header: requestHeader(request)}
+		c, err := req.Cookie(defaultConfig.StickyCookieName)
+		if err != nil {
+			return ""
+		}
+		return c.Value
+	case stickyHeader:
+		return requestHeader(request).Get(defaultConfig.StickyHeaderName)
+	}
+	return ""
+}
+
+// lookupBackend はリクエストを送るバックエンドを選ぶ
+// 前回のバックエンドがまだ登録されていればそれを選び、いなくなっていれば改めて選ぶ
+func (s *RelayServer) lookupBackend(request *remote.HttpRequestWrapper) (*registry.Backend, bool) {
+	domain := registry.Domain(request.Domain)
+	if a := affinity(request); a != "" {
+		if b, ok := s.registry.LookupByAffinity(domain, a); ok {
+			return b, true
+		}
+		log.Debug().Str("domain", request.Domain).Msg("sticky backend is gone")
+	}
+	return s.registry.Lookup(domain)
+}
+
+// stick は次のリクエストも同じバックエンドに送るための目印をレスポンスに付ける
+// バックエンドのIDはフロントに見せず、relayの中でだけバックエンドと対応する目印を使う
+func stick(request *remote.HttpRequestWrapper, backend *registry.Backend, response *remote.HttpResponseWrapper) {
+	if defaultConfig.StickySession == "" || affinity(request) == backend.Affinity {
+		return
+	}
+	if response.Headers == nil {
+		response.Headers = map[string]*remote.HttpHeader{}
+	}
+
+	switch defaultConfig.StickySession {
+	case stickyCookie:
+		c := &http.Cookie{
+			Name:     defaultConfig.StickyCookieName,
+			Value:    backend.Affinity,
+			Path:     "/",
+			HttpOnly: true,
+		}
+		h, ok := response.Headers["Set-Cookie"]
+		if !ok {
+			h = &remote.HttpHeader{Key: "Set-Cookie"}
+			response.Headers["Set-Cookie"] = h
+		}
+		h.Value = append(h.Value, c.String())
+	case stickyHeader:
+		key := http.CanonicalHeaderKey(defaultConfig.StickyHeaderName)
+		response.Headers[key] = &remote.HttpHeader{Key: key, Value: []string{backend.Affinity}}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+)
+
+// 目印を付けたリクエストは同じバックエンドに届き、目印からバックエンドのIDは分からない
+func TestStickySession(t *testing.T) {
+	defer func(mode string) {
+		defaultConfig.StickySession = mode
+	}(defaultConfig.StickySession)
+
+	for _, mode := range []string{stickyCookie, stickyHeader} {
+		t.Run(mode, func(t *testing.T) {
+			defaultConfig.StickySession = mode
+			r := registry.New(registry.Options{Policy: registry.PolicyPool})
+			s := NewRelayServer(r)
+			var backends []*registry.Backend
+			for _, name := range []string{"alice", "bob", "carol"} {
+				b, err := r.Register(registry.BackendOptions{Domain: "alice.test", DeveloperName: name})
+				if err != nil {
+					t.Fatal(err)
+				}
+				backends = append(backends, b)
+			}
+
+			first, ok := s.lookupBackend(&remote.HttpRequestWrapper{Domain: "alice.test"})
+			if !ok {
+				t.Fatal("lookup fails")
+			}
+			response := &remote.HttpResponseWrapper{}
+			stick(&remote.HttpRequestWrapper{Domain: "alice.test"}, first, response)
+			header := http.Header{}
+			for _, h := range response.Headers {
+				header[h.Key] = h.Value
+			}
+			// 受け取った目印をそのまま次のリクエストに付ける
+			request := &remote.HttpRequestWrapper{Domain: "alice.test", Headers: map[string]*remote.HttpHeader{}}
+			if mode == stickyCookie {
+				resp := http.Response{Header: header}
+				cookies := resp.Cookies()
+				if len(cookies) != 1 {
+					t.Fatalf("cookies: got %v", cookies)
+				}
+				request.Headers["Cookie"] = &remote.HttpHeader{Key: "Cookie", Value: []string{cookies[0].Name + "=" + cookies[0].Value}}
+			} else {
+				key := http.CanonicalHeaderKey(defaultConfig.StickyHeaderName)
+				request.Headers[key] = &remote.HttpHeader{Key: key, Value: header.Values(key)}
+			}
+
+			a := affinity(request)
+			for _, b := range backends {
+				if a == b.ID {
+					t.Fatalf("affinity is the backend id of %s", b.DeveloperName)
+				}
+			}
+			for i := 0; i < len(backends)*2; i++ {
+				if b, _ := s.lookupBackend(request); b != first {
+					t.Fatalf("request %d is sent to %s, want %s", i, b.DeveloperName, first.DeveloperName)
+				}
+			}
+
+			// バックエンドのIDを目印にしても選べない
+			if _, ok := r.LookupByAffinity("alice.test", backends[2].ID); ok {
+				t.Error("backend is found by id")
+			}
+
+			// 解除されたバックエンドの目印は改めて選び直す
+			r.Unregister(first)
+			if b, ok := s.lookupBackend(request); !ok || b == first {
+				t.Errorf("unregistered backend is picked: %v", ok)
+			}
+		})
+	}
+}
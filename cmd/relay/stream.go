@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FrontendStream はclientとの間でボディを分割して送受信する
+func (s *RelayServer) FrontendStream(stream remote.Proxy_FrontendStreamServer) error {
+	ctx := stream.Context()
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	request := first.GetRequest()
+	if request == nil {
+		return status.Error(codes.InvalidArgument, "first frame must be request")
+	}
+
+	if request.Protocol == remote.Protocol_PROTOCOL_UDP {
+		return s.relayDatagrams(stream, request)
+	}
+
+	connectionID := registry.ConnectionID(request.ConnectionId)
+	conn, err := s.registry.Open(connectionID)
+	if err != nil {
+		return err
+	}
+	defer s.registry.Close(connectionID)
+
+	backend, ok := s.lookupBackend(request)
+	if !ok {
+		return status.Error(codes.NotFound, registry.ErrDomainNotRegistered.Error())
+	}
+	defer backend.Begin()()
+
+	if request.Protocol != backend.Protocol {
+		return status.Errorf(codes.FailedPrecondition, "backend does not accept %s", request.Protocol)
+	}
+	if request.Upgrade && !backend.Streaming {
+		return status.Error(codes.FailedPrecondition, "backend does not support upgrade")
+	}
+	// Tunnelを使っていないバックエンドにはボディをまとめて渡す
+	if request.StreamBody && !backend.Streaming {
+		body, err := readBody(stream)
+		if err != nil {
+			return err
+		}
+		request.Body = body
+		request.StreamBody = false
+	}
+
+	if err := s.send(ctx, backend, request); err != nil {
+		return err
+	}
+
+	if request.StreamBody {
+		go func() {
+			if err := forwardRequestBody(ctx, stream, conn, backend); err != nil {
+				log.Warn().Err(err).Str("connection_id", connectionID.String()).Msg("failed to forward request body")
+				backend.Cancel(connectionID)
+			}
+		}()
+	}
+
+	// 期限はレスポンスのヘッダーが届くまでに適用し、ボディはevent-streamなどのために期限なく流す
+	response, err := s.wait(ctx, conn, backend, request.Deadline)
+	if err != nil {
+		return err
+	}
+	if request.Protocol == remote.Protocol_PROTOCOL_HTTP {
+		stick(request, backend, response)
+	}
+	if err := stream.Send(&remote.FrontendFrame{
+		Frame: &remote.FrontendFrame_Response{Response: response},
+	}); err != nil {
+		return err
+	}
+	if !response.StreamBody {
+		return nil
+	}
+
+	window := newBodyWindow(backend, connectionID)
+	for {
+		select {
+		case chunk := <-conn.Bodies():
+			if err := stream.Send(&remote.FrontendFrame{
+				Frame: &remote.FrontendFrame_Body{Body: chunk},
+			}); err != nil {
+				backend.Cancel(connectionID)
+				return err
+			}
+			if chunk.Eof {
+				return nil
+			}
+			window.consume(ctx)
+		case <-conn.Done():
+			// フロントかバックエンドが遅すぎてボディを溜めきれなかった
+			backend.Cancel(connectionID)
+			return status.Error(codes.ResourceExhausted, registry.ErrBodyOverflow.Error())
+		case <-backend.Done():
+			return status.Error(codes.Unavailable, registry.ErrBackendClosed.Error())
+		case <-ctx.Done():
+			backend.Cancel(connectionID)
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// readBody はclientから届くボディを最後まで読む
+func readBody(stream remote.Proxy_FrontendStreamServer) ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		frame, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		chunk := frame.GetBody()
+		if chunk == nil {
+			return nil, status.Error(codes.InvalidArgument, "body frame is expected")
+		}
+		if chunk.Error != "" {
+			return nil, status.Error(codes.Aborted, chunk.Error)
+		}
+		buf.Write(chunk.Data)
+		if chunk.Eof {
+			return buf.Bytes(), nil
+		}
+	}
+}
+
+// forwardRequestBody はclientから届くボディをそのままバックエンドに流す
+// RequestFlowControlの場合はバックエンドが受け取れる分だけ流し、それまでclientからの受信を止める
+func forwardRequestBody(ctx context.Context, stream remote.Proxy_FrontendStreamServer, conn *registry.Connection, backend *registry.Backend) error {
+	for {
+		frame, err := stream.Recv()
+		if err == io.EOF {
+			return errors.New("request body is closed before eof")
+		}
+		if err != nil {
+			return err
+		}
+		chunk := frame.GetBody()
+		if chunk == nil {
+			return status.Error(codes.InvalidArgument, "body frame is expected")
+		}
+		chunk.ConnectionId = conn.ID.String()
+		// backend-connecterはデータを含むBodyChunkだけを数える
+		if backend.RequestFlowControl && len(chunk.Data) != 0 {
+			if err := conn.AcquireBody(ctx); err != nil {
+				return err
+			}
+		}
+		if err := backend.SendBody(ctx, chunk); err != nil {
+			return err
+		}
+		if chunk.Eof {
+			return nil
+		}
+	}
+}
+
+// bodyWindow はフロントに渡したレスポンスのボディを数え、まとめてバックエンドに伝える
+// バックエンドは伝えた分だけ続きを送るので、コネクションに溜まるボディはremote.BodyWindowを超えない
+type bodyWindow struct {
+	backend  *registry.Backend
+	id       registry.ConnectionID
+	consumed int
+}
+
+func newBodyWindow(backend *registry.Backend, id registry.ConnectionID) *bodyWindow {
+	return &bodyWindow{backend: backend, id: id}
+}
+
+// consume はボディを1つフロントに渡したときに呼ぶ
+func (w *bodyWindow) consume(ctx context.Context) {
+	// Tunnelを使っていないバックエンドはボディを分けて返さない
+	if !w.backend.Streaming {
+		return
+	}
+	w.consumed++
+	if w.consumed < remote.BodyWindow/2 {
+		return
+	}
+	// 伝えられないのはバックエンドかフロントがいなくなったときなので、呼び出し元のループで気付く
+	if err := w.backend.Grant(ctx, w.id, w.consumed); err != nil {
+		return
+	}
+	w.consumed = 0
+}
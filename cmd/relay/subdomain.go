@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DNSのラベルの最大の長さ
+const maxLabelLength = 63
+
+// registrations はConnectionで登録するドメインを返す
+func registrations(con *remote.Connection) []*remote.DomainRegistration {
+	if len(con.Domains) != 0 {
+		return con.Domains
+	}
+	return []*remote.DomainRegistration{{Domain: con.Domain, Protocol: con.Protocol}}
+}
+
+// registerDomain はi番目に登録するドメインを返す
+// BASE_DOMAINが設定されている場合はバックエンドが指定したドメインではなくDeveloperNameからサブドメインを割り当てる
+// 2つ目以降は指定したドメインの先頭のラベルを更に付けて alice.dev.example.test, api.alice.dev.example.test のようにする
+func registerDomain(developerName string, reg *remote.DomainRegistration, i int) (registry.Domain, error) {
+	if defaultConfig.BaseDomain == "" {
+		return registry.Domain(reg.Domain), nil
+	}
+	// Aliceとaliceのように別の名前が同じサブドメインにならないように、そのままラベルとして使える名前だけを受け付ける
+	label := subdomainLabel(developerName)
+	if label == "" || label != developerName {
+		return "", status.Errorf(codes.InvalidArgument, "developer name %q cannot be used as subdomain. use lowercase letters, digits and hyphens", developerName)
+	}
+	domain := label + "." + baseDomain()
+	if i == 0 {
+		return registry.Domain(domain), nil
+	}
+
+	host := reg.Domain
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sub := subdomainLabel(strings.SplitN(host, ".", 2)[0])
+	if sub == "" {
+		return "", status.Errorf(codes.InvalidArgument, "domain %q cannot be used as subdomain", reg.Domain)
+	}
+	return registry.Domain(sub + "." + domain), nil
+}
+
+func baseDomain() string {
+	return strings.ToLower(strings.Trim(defaultConfig.BaseDomain, "."))
+}
+
+// subdomainLabel はDeveloperNameを小文字の英数字と-だけのラベルにする
+func subdomainLabel(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case 'a' <= r && r <= 'z', '0' <= r && r <= '9':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	label := b.String()
+	if len(label) > maxLabelLength {
+		label = label[:maxLabelLength]
+	}
+	return strings.Trim(label, "-")
+}
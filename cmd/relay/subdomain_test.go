@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSubdomainLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "alice", want: "alice"},
+		{name: "Alice", want: "alice"},
+		{name: "alice.smith", want: "alice-smith"},
+		{name: "-alice_", want: "alice"},
+		{name: "アリス", want: ""},
+		{name: "a123456789012345678901234567890123456789012345678901234567890123456789", want: "a12345678901234567890123456789012345678901234567890123456789012"},
+	}
+	for _, tt := range tests {
+		if got := subdomainLabel(tt.name); got != tt.want {
+			t.Errorf("subdomainLabel(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterDomain(t *testing.T) {
+	defer func(base string) {
+		defaultConfig.BaseDomain = base
+	}(defaultConfig.BaseDomain)
+	defaultConfig.BaseDomain = "Dev.Example.Test."
+
+	tests := []struct {
+		name      string
+		developer string
+		domain    string
+		i         int
+		want      registry.Domain
+		wantCode  codes.Code
+	}{
+		{name: "first domain", developer: "alice", domain: "web.local", want: "alice.dev.example.test"},
+		{name: "second domain", developer: "alice", domain: "API.local:8080", i: 1, want: "api.alice.dev.example.test"},
+		{name: "uppercase", developer: "Alice", domain: "web.local", wantCode: codes.InvalidArgument},
+		{name: "dot", developer: "alice.smith", domain: "web.local", wantCode: codes.InvalidArgument},
+		{name: "no label", developer: "アリス", domain: "web.local", wantCode: codes.InvalidArgument},
+		{name: "invalid second domain", developer: "alice", domain: "_.local", i: 1, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := registerDomain(tt.developer, &remote.DomainRegistration{Domain: tt.domain}, tt.i)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("code = %s, want %s", code, tt.wantCode)
+			}
+			if got != tt.want {
+				t.Fatalf("domain = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// BASE_DOMAINがない場合は指定されたドメインをそのまま使う
+	defaultConfig.BaseDomain = ""
+	got, err := registerDomain("Alice", &remote.DomainRegistration{Domain: "web.local"}, 0)
+	if err != nil || got != "web.local" {
+		t.Fatalf("domain = %q, err = %v", got, err)
+	}
+}
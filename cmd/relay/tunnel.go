@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errHeartbeatTimeout = errors.New("heartbeat timeout")
+
+func controlFrame(c *remote.Control) *remote.TunnelFrame {
+	return &remote.TunnelFrame{
+		Frame: &remote.TunnelFrame_Control{Control: c},
+	}
+}
+
+// Tunnel はbackend-connecterとの間でリクエスト、レスポンス、ハートビートをやり取りする
+// 最初のフレームはRegisterでなければならない
+func (s *RelayServer) Tunnel(stream remote.Proxy_TunnelServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	con := first.GetRegister()
+	if con == nil {
+		return status.Error(codes.InvalidArgument, "first frame must be register")
+	}
+
+	regs := registrations(con)
+	domains := make([]registry.Domain, 0, len(regs))
+	seen := map[registry.Domain]struct{}{}
+	for i, reg := range regs {
+		domain, err := registerDomain(con.DeveloperName, reg, i)
+		if err != nil {
+			return err
+		}
+		// 同じドメインを2回登録すると自分で自分を奪ってしまう
+		if _, ok := seen[domain]; ok {
+			return status.Errorf(codes.InvalidArgument, "domain %s is registered twice", domain)
+		}
+		seen[domain] = struct{}{}
+		domains = append(domains, domain)
+	}
+	if err := s.auth.authorize(stream.Context(), con.DeveloperName, domains); err != nil {
+		return err
+	}
+
+	// Drainされていたり他の開発者が使っていたりして登録できないドメインは飛ばし、残りだけでトンネルを使う
+	var backends []*registry.Backend
+	defer func() {
+		for _, b := range backends {
+			s.registry.Unregister(b)
+		}
+	}()
+	var (
+		ids        []string
+		registered []*remote.DomainRegistration
+		failed     error
+	)
+	for i, domain := range domains {
+		backend, err := s.registry.Register(registry.BackendOptions{
+			Domain:        domain,
+			DeveloperName: con.DeveloperName,
+			Streaming:     true,
+			Protocol:      regs[i].Protocol,
+			RemoteAddr:    remoteAddr(stream.Context()),
+			Weight:        int(con.Weight),
+			// リクエストのボディを受け取った数を伝えてくるbackend-connecterには、伝えられた分だけ送る
+			RequestFlowControl: con.FlowControl,
+		})
+		if err != nil {
+			log.Warn().Err(err).Str("domain", domain.String()).Msg("failed to register domain")
+			failed = err
+			// backend-connecterがConnection.Domainsと対応させられるように登録できなかったものも返す
+			registered = append(registered, &remote.DomainRegistration{
+				Domain:   domain.String(),
+				Protocol: regs[i].Protocol,
+				Error:    err.Error(),
+			})
+			continue
+		}
+		backends = append(backends, backend)
+		ids = append(ids, backend.ID)
+		registered = append(registered, &remote.DomainRegistration{
+			Domain:    backend.Domain.String(),
+			Protocol:  backend.Protocol,
+			BackendId: backend.ID,
+		})
+	}
+
+	if len(backends) == 0 {
+		return registerError(failed)
+	}
+
+	if err := stream.Send(controlFrame(&remote.Control{
+		Type:               remote.ControlType_CONTROL_REGISTERED,
+		BackendId:          backends[0].ID,
+		Domain:             backends[0].Domain.String(),
+		Registrations:      registered,
+		FlowControl:        true,
+		RequestFlowControl: con.FlowControl,
+	})); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	queue := newTunnelQueue(ctx, backends)
+
+	// 受信はgoroutineで行い、送信はこの関数のループだけで行う
+	received := make(chan struct{}, 1)
+	heartbeats := make(chan *remote.TunnelFrame, 1)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			frame, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case received <- struct{}{}:
+			default:
+			}
+
+			switch f := frame.Frame.(type) {
+			case *remote.TunnelFrame_Response:
+				// 期限切れなどでフロントが待っていないレスポンスは捨てる
+				if err := s.registry.Deliver(f.Response); err != nil {
+					log.Warn().Err(err).Str("connection_id", f.Response.GetConnectionId()).Msg("drop response")
+				}
+			case *remote.TunnelFrame_Body:
+				// backend-connecterはCONTROL_WINDOWを待って送るので溢れるのはフロントが受け取れないときだけ
+				// 待つと他のリクエストも止まるので、溢れたコネクションはDeliverBodyが閉じる
+				err := s.registry.DeliverBody(f.Body)
+				if err == registry.ErrBodyOverflow {
+					// フロントが止まっていると受け渡すgoroutineも気付けないのでここで取り消す
+					queue.cancel(registry.ConnectionID(f.Body.GetConnectionId()))
+				}
+				if err != nil {
+					log.Warn().Err(err).Str("connection_id", f.Body.GetConnectionId()).Msg("drop body")
+				}
+			case *remote.TunnelFrame_Heartbeat:
+				// backend-connecterが切断を検知できるように送り返す
+				select {
+				case heartbeats <- frame:
+				default:
+				}
+			case *remote.TunnelFrame_Control:
+				id := registry.ConnectionID(f.Control.GetConnectionId())
+				switch f.Control.GetType() {
+				case remote.ControlType_CONTROL_WINDOW:
+					s.registry.ReleaseBody(id, int(f.Control.GetWindow()))
+				case remote.ControlType_CONTROL_CANCEL:
+					// backend-connecterがリクエストのボディを溜めきれなかった. 待っているフロントに伝える
+					log.Warn().Str("connection_id", id.String()).Str("reason", f.Control.GetMessage()).Msg("request is canceled by backend")
+					s.registry.Close(id)
+				case remote.ControlType_CONTROL_CLOSE:
+					log.Info().Strs("backend_ids", ids).Str("reason", f.Control.GetMessage()).Msg("tunnel is closed by backend")
+					recvErr <- io.EOF
+					return
+				}
+			default:
+				log.Warn().Strs("backend_ids", ids).Msgf("unexpected frame: %T", f)
+			}
+		}
+	}()
+
+	remaining := len(backends)
+	timer := time.NewTimer(defaultConfig.HeartbeatTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case request := <-queue.requests:
+			if err := stream.Send(&remote.TunnelFrame{
+				Frame: &remote.TunnelFrame_Request{Request: request},
+			}); err != nil {
+				return err
+			}
+		case chunk := <-queue.bodies:
+			if err := stream.Send(&remote.TunnelFrame{
+				Frame: &remote.TunnelFrame_Body{Body: chunk},
+			}); err != nil {
+				return err
+			}
+		case id := <-queue.cancels:
+			if err := stream.Send(controlFrame(&remote.Control{
+				Type:         remote.ControlType_CONTROL_CANCEL,
+				ConnectionId: id.String(),
+			})); err != nil {
+				return err
+			}
+		case w := <-queue.windows:
+			if err := stream.Send(controlFrame(&remote.Control{
+				Type:         remote.ControlType_CONTROL_WINDOW,
+				ConnectionId: w.ConnectionID.String(),
+				Window:       int32(w.Size),
+			})); err != nil {
+				return err
+			}
+		case <-received:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(defaultConfig.HeartbeatTimeout)
+		case frame := <-heartbeats:
+			if err := stream.Send(frame); err != nil {
+				return err
+			}
+		case <-timer.C:
+			return status.Error(codes.DeadlineExceeded, errHeartbeatTimeout.Error())
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case backend := <-queue.closed:
+			// 登録が解除されたバックエンドだけを伝え、全て解除されたらトンネルを閉じる
+			t := remote.ControlType_CONTROL_CLOSE
+			if backend.Reason() == registry.ReasonTakenOver {
+				t = remote.ControlType_CONTROL_DISPLACED
+			}
+			if err := stream.Send(controlFrame(&remote.Control{
+				Type:      t,
+				BackendId: backend.ID,
+				Message:   backend.Reason(),
+				Domain:    backend.Domain.String(),
+			})); err != nil {
+				return err
+			}
+			remaining--
+			if remaining == 0 {
+				return nil
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// tunnelQueue は1つのTunnelで登録した全てのバックエンドに届いたものを集める
+type tunnelQueue struct {
+	requests chan *remote.HttpRequestWrapper
+	bodies   chan *remote.BodyChunk
+	cancels  chan registry.ConnectionID
+	windows  chan registry.Window
+	// 登録が解除されたバックエンド
+	closed chan *registry.Backend
+}
+
+func newTunnelQueue(ctx context.Context, backends []*registry.Backend) *tunnelQueue {
+	q := &tunnelQueue{
+		requests: make(chan *remote.HttpRequestWrapper),
+		bodies:   make(chan *remote.BodyChunk),
+		cancels:  make(chan registry.ConnectionID, 64),
+		windows:  make(chan registry.Window),
+		closed:   make(chan *registry.Backend, len(backends)),
+	}
+	for _, b := range backends {
+		go q.collect(ctx, b)
+	}
+	return q
+}
+
+// cancel はTunnelで処理中のリクエストを取り消す
+// 取り消しはConnectionIDだけで伝わるので、どのバックエンドのリクエストかは問わない. 詰まっている場合は捨てる
+func (q *tunnelQueue) cancel(id registry.ConnectionID) {
+	select {
+	case q.cancels <- id:
+	default:
+	}
+}
+
+// collect はバックエンドに届いたものを届いた順にqに渡す
+// backend-connecterが送り先を選べるようにリクエストにはバックエンドのIDを入れる
+func (q *tunnelQueue) collect(ctx context.Context, b *registry.Backend) {
+	for {
+		select {
+		case request := <-b.Requests():
+			request.BackendId = b.ID
+			select {
+			case q.requests <- request:
+			case <-ctx.Done():
+				return
+			}
+		case chunk := <-b.Bodies():
+			select {
+			case q.bodies <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		case id := <-b.Cancels():
+			select {
+			case q.cancels <- id:
+			case <-ctx.Done():
+				return
+			}
+		case w := <-b.Windows():
+			select {
+			case q.windows <- w:
+			case <-ctx.Done():
+				return
+			}
+		case <-b.Done():
+			q.closed <- b
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
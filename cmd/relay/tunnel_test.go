@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// backend-connecterと同じ大きさに分けて流す
+const testChunkSize = 32 * 1024
+
+// eachChunk はsizeバイトの決まった内容のボディを分けてfに渡す
+func eachChunk(size int, f func([]byte) error) error {
+	for sent, i := 0, uint64(0); sent < size; i++ {
+		n := testChunkSize
+		if size-sent < n {
+			n = size - sent
+		}
+		data := bytes.Repeat([]byte{byte(i)}, n)
+		binary.BigEndian.PutUint64(data, i)
+		if err := f(data); err != nil {
+			return err
+		}
+		sent += n
+	}
+	return nil
+}
+
+func bodySum(size int) [sha256.Size]byte {
+	h := sha256.New()
+	eachChunk(size, func(data []byte) error {
+		h.Write(data)
+		return nil
+	})
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// openTunnel はbackend-connecterとしてTunnelでドメインを登録する
+func openTunnel(ctx context.Context, t *testing.T, client remote.ProxyClient, domain string) remote.Proxy_TunnelClient {
+	t.Helper()
+	tunnel, err := client.Tunnel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tunnel.Send(&remote.TunnelFrame{
+		Frame: &remote.TunnelFrame_Register{Register: &remote.Connection{
+			Domain:        domain,
+			DeveloperName: "alice",
+			Protocol:      remote.Protocol_PROTOCOL_HTTP,
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := tunnel.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.GetControl().GetType() != remote.ControlType_CONTROL_REGISTERED {
+		t.Fatalf("unexpected frame: %v", first)
+	}
+	if !first.GetControl().GetFlowControl() {
+		t.Fatal("relay does not control response body flow")
+	}
+	return tunnel
+}
+
+// peakHeap は止めるまでヒープの使用量を測り、測り始めからの最大の増加量を返す
+func peakHeap() func() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	base := stats.HeapInuse
+
+	var peak uint64
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse > base && stats.HeapInuse-base > atomic.LoadUint64(&peak) {
+				atomic.StoreUint64(&peak, stats.HeapInuse-base)
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() uint64 {
+		close(stop)
+		<-done
+		return atomic.LoadUint64(&peak)
+	}
+}
+
+// gRPCの1メッセージの上限(4MB)より大きいボディを両方向に流し、全てを溜め込まずに届くことを確かめる
+func TestTunnelStreamsLargeBodies(t *testing.T) {
+	const size = 64 << 20
+	r := registry.New(registry.Options{})
+	client := startRelay(t, NewRelayServer(r))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tunnel := openTunnel(ctx, t, client, "alice.test")
+	stopHeap := peakHeap()
+
+	// backend-connecter: リクエストのボディを受け取りながら、CONTROL_WINDOWを待ってレスポンスのボディを返す
+	requestSum := make(chan [sha256.Size]byte, 1)
+	connecterErr := make(chan error, 1)
+	go func() {
+		requests := make(chan *remote.HttpRequestWrapper, 1)
+		window := make(chan struct{}, remote.BodyWindow)
+		go func() {
+			h := sha256.New()
+			for {
+				frame, err := tunnel.Recv()
+				if err != nil {
+					return
+				}
+				switch f := frame.Frame.(type) {
+				case *remote.TunnelFrame_Request:
+					requests <- f.Request
+				case *remote.TunnelFrame_Body:
+					h.Write(f.Body.Data)
+					if f.Body.Eof {
+						var sum [sha256.Size]byte
+						copy(sum[:], h.Sum(nil))
+						requestSum <- sum
+					}
+				case *remote.TunnelFrame_Control:
+					if f.Control.Type == remote.ControlType_CONTROL_WINDOW {
+						for i := 0; i < int(f.Control.Window); i++ {
+							<-window
+						}
+					}
+				}
+			}
+		}()
+
+		request := <-requests
+		id := request.ConnectionId
+		if err := tunnel.Send(&remote.TunnelFrame{
+			Frame: &remote.TunnelFrame_Response{Response: &remote.HttpResponseWrapper{
+				ConnectionId: id,
+				Status:       200,
+				StreamBody:   true,
+			}},
+		}); err != nil {
+			connecterErr <- err
+			return
+		}
+		send := func(chunk *remote.BodyChunk) error {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return tunnel.Send(&remote.TunnelFrame{
+				Frame: &remote.TunnelFrame_Body{Body: chunk},
+			})
+		}
+		if err := eachChunk(size, func(data []byte) error {
+			return send(&remote.BodyChunk{ConnectionId: id, Data: data})
+		}); err != nil {
+			connecterErr <- err
+			return
+		}
+		connecterErr <- send(&remote.BodyChunk{ConnectionId: id, Eof: true})
+	}()
+
+	// client: リクエストのボディを流しながらレスポンスを受け取る
+	id := uuid.New().String()
+	stream, err := client.FrontendStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&remote.FrontendFrame{
+		Frame: &remote.FrontendFrame_Request{Request: &remote.HttpRequestWrapper{
+			ConnectionId: id,
+			Domain:       "alice.test",
+			HttpMethod:   "POST",
+			StreamBody:   true,
+			Protocol:     remote.Protocol_PROTOCOL_HTTP,
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	clientErr := make(chan error, 1)
+	go func() {
+		if err := eachChunk(size, func(data []byte) error {
+			return stream.Send(&remote.FrontendFrame{
+				Frame: &remote.FrontendFrame_Body{Body: &remote.BodyChunk{ConnectionId: id, Data: data}},
+			})
+		}); err != nil {
+			clientErr <- err
+			return
+		}
+		clientErr <- stream.Send(&remote.FrontendFrame{
+			Frame: &remote.FrontendFrame_Body{Body: &remote.BodyChunk{ConnectionId: id, Eof: true}},
+		})
+	}()
+
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.GetResponse().GetStatus() != 200 {
+		t.Fatalf("unexpected response: %v", first)
+	}
+	h := sha256.New()
+	received := 0
+	for {
+		frame, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk := frame.GetBody()
+		h.Write(chunk.Data)
+		received += len(chunk.Data)
+		if chunk.Eof {
+			break
+		}
+	}
+	peak := stopHeap()
+
+	if err := <-clientErr; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-connecterErr; err != nil {
+		t.Fatal(err)
+	}
+	want := bodySum(size)
+	if received != size || !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Errorf("response body is broken: received %d bytes", received)
+	}
+	select {
+	case got := <-requestSum:
+		if got != want {
+			t.Error("request body is broken")
+		}
+	case <-ctx.Done():
+		t.Fatal("request body is not received")
+	}
+	// 流れている分だけを持っていれば、ボディの大きさに関わらず数MBで済む
+	if limit := uint64(size / 2); peak > limit {
+		t.Errorf("heap grows by %d bytes while streaming, want at most %d", peak, limit)
+	}
+}
+
+// CONTROL_WINDOWを待たないbackend-connecterが受け取らないフロントにボディを送り続けても
+// そのコネクションだけが閉じられ、同じTunnelの他のリクエストは止まらない
+func TestTunnelSlowFrontendDoesNotBlockOthers(t *testing.T) {
+	r := registry.New(registry.Options{})
+	client := startRelay(t, NewRelayServer(r))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tunnel := openTunnel(ctx, t, client, "alice.test")
+
+	open := func() (remote.Proxy_FrontendStreamClient, string) {
+		id := uuid.New().String()
+		stream, err := client.FrontendStream(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.Send(&remote.FrontendFrame{
+			Frame: &remote.FrontendFrame_Request{Request: &remote.HttpRequestWrapper{
+				ConnectionId: id,
+				Domain:       "alice.test",
+				HttpMethod:   "GET",
+				Protocol:     remote.Protocol_PROTOCOL_HTTP,
+			}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return stream, id
+	}
+	slow, slowID := open()
+	fast, fastID := open()
+
+	// 2つのリクエストが届いてから返し始める
+	canceled := make(chan string, 1)
+	for i := 0; i < 2; i++ {
+		frame, err := tunnel.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.GetRequest() == nil {
+			t.Fatalf("unexpected frame: %v", frame)
+		}
+	}
+	go func() {
+		for {
+			frame, err := tunnel.Recv()
+			if err != nil {
+				return
+			}
+			if c := frame.GetControl(); c.GetType() == remote.ControlType_CONTROL_CANCEL {
+				canceled <- c.ConnectionId
+			}
+		}
+	}()
+
+	for _, id := range []string{slowID, fastID} {
+		if err := tunnel.Send(&remote.TunnelFrame{
+			Frame: &remote.TunnelFrame_Response{Response: &remote.HttpResponseWrapper{
+				ConnectionId: id,
+				Status:       200,
+				StreamBody:   true,
+			}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// gRPCのバッファでも受け止めきれない量を受け取らないフロントに送る
+	if err := eachChunk(16<<20, func(data []byte) error {
+		return tunnel.Send(&remote.TunnelFrame{
+			Frame: &remote.TunnelFrame_Body{Body: &remote.BodyChunk{ConnectionId: slowID, Data: data}},
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tunnel.Send(&remote.TunnelFrame{
+		Frame: &remote.TunnelFrame_Body{Body: &remote.BodyChunk{ConnectionId: fastID, Data: []byte("fast"), Eof: true}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fast.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := fast.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(frame.GetBody().GetData()) != "fast" || !frame.GetBody().GetEof() {
+		t.Errorf("unexpected body: %v", frame)
+	}
+
+	select {
+	case id := <-canceled:
+		if id != slowID {
+			t.Errorf("canceled %s, want %s", id, slowID)
+		}
+	case <-ctx.Done():
+		t.Fatal("overflowed request is not canceled")
+	}
+	for {
+		_, err := slow.Recv()
+		if err == nil {
+			continue
+		}
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("slow frontend: got %v, want %v", err, codes.ResourceExhausted)
+		}
+		break
+	}
+}
+
+// 登録できないドメインや解除されたドメインがあっても、残りのドメインでトンネルを使い続ける
+func TestTunnelRegistersDomainsIndividually(t *testing.T) {
+	r := registry.New(registry.Options{Policy: registry.PolicyTakeover})
+	r.Drain("c.test")
+	client := startRelay(t, NewRelayServer(r))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tunnel, err := client.Tunnel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tunnel.Send(&remote.TunnelFrame{
+		Frame: &remote.TunnelFrame_Register{Register: &remote.Connection{
+			DeveloperName: "alice",
+			Domains: []*remote.DomainRegistration{
+				{Domain: "a.test"},
+				{Domain: "b.test"},
+				{Domain: "c.test"},
+			},
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := tunnel.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	regs := first.GetControl().GetRegistrations()
+	if len(regs) != 3 {
+		t.Fatalf("registrations: got %d, want 3", len(regs))
+	}
+	for i, wantErr := range []bool{false, false, true} {
+		if got := regs[i].GetError() != ""; got != wantErr {
+			t.Errorf("registration %s: error %q", regs[i].GetDomain(), regs[i].GetError())
+		}
+	}
+
+	// 奪われたドメインだけが伝えられ、トンネルは残る
+	if _, err := r.Register(registry.BackendOptions{Domain: "b.test", DeveloperName: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := tunnel.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := frame.GetControl(); c.GetType() != remote.ControlType_CONTROL_DISPLACED || c.GetBackendId() != regs[1].GetBackendId() {
+		t.Fatalf("unexpected frame: %v", frame)
+	}
+	a, ok := r.Get(regs[0].GetBackendId())
+	if !ok {
+		t.Fatal("a.test is unregistered with b.test")
+	}
+
+	// 最後のドメインが解除されたらトンネルを閉じる
+	r.Unregister(a)
+	frame, err = tunnel.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := frame.GetControl(); c.GetType() != remote.ControlType_CONTROL_CLOSE || c.GetBackendId() != a.ID {
+		t.Fatalf("unexpected frame: %v", frame)
+	}
+	if _, err := tunnel.Recv(); err != io.EOF {
+		t.Errorf("tunnel is not closed: %v", err)
+	}
+}
+
+// 1つも登録できなければトンネルを使わない
+func TestTunnelAllDomainsDrained(t *testing.T) {
+	r := registry.New(registry.Options{})
+	r.Drain("a.test")
+	client := startRelay(t, NewRelayServer(r))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tunnel, err := client.Tunnel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tunnel.Send(&remote.TunnelFrame{
+		Frame: &remote.TunnelFrame_Register{Register: &remote.Connection{
+			Domain:        "a.test",
+			DeveloperName: "alice",
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tunnel.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("got %v, want %v", err, codes.Unavailable)
+	}
+}
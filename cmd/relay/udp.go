@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/ieee0824/virtual-neighbor-proxy/registry"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// udpSession はclientの送信元アドレス1つとバックエンドのUDPのセッション1つの組
+type udpSession struct {
+	sourceAddr string
+	conn       *registry.Connection
+}
+
+// relayDatagrams はclientの1つのUDPポートに届くデータグラムを送信元アドレスごとのセッションに分けてバックエンドに流す
+// セッションはバックエンドが閉じるまで使い回す
+func (s *RelayServer) relayDatagrams(stream remote.Proxy_FrontendStreamServer, request *remote.HttpRequestWrapper) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	backend, ok := s.registry.Lookup(registry.Domain(request.Domain))
+	if !ok {
+		return status.Error(codes.NotFound, registry.ErrDomainNotRegistered.Error())
+	}
+	defer backend.Begin()()
+	if backend.Protocol != remote.Protocol_PROTOCOL_UDP {
+		return status.Errorf(codes.FailedPrecondition, "backend does not accept %s", request.Protocol)
+	}
+	if err := stream.Send(&remote.FrontendFrame{
+		Frame: &remote.FrontendFrame_Response{Response: &remote.HttpResponseWrapper{
+			ConnectionId: request.ConnectionId,
+			Status:       http.StatusOK,
+			StreamBody:   true,
+		}},
+	}); err != nil {
+		return err
+	}
+
+	sessions := map[string]*udpSession{}
+	defer func() {
+		for _, sess := range sessions {
+			backend.Cancel(sess.conn.ID)
+			s.registry.Close(sess.conn.ID)
+		}
+	}()
+
+	incoming := make(chan *remote.BodyChunk)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			frame, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			chunk := frame.GetBody()
+			if chunk == nil {
+				recvErr <- status.Error(codes.InvalidArgument, "body frame is expected")
+				return
+			}
+			select {
+			case incoming <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// セッションごとのgoroutineからclientに返すデータグラム
+	outgoing := make(chan *remote.BodyChunk)
+	ended := make(chan *udpSession)
+
+	for {
+		select {
+		case chunk := <-incoming:
+			if chunk.Eof {
+				return nil
+			}
+			sess, ok := sessions[chunk.SourceAddr]
+			if !ok {
+				var err error
+				sess, err = s.openUDPSession(ctx, backend, request, chunk.SourceAddr, outgoing, ended)
+				if err != nil {
+					return err
+				}
+				sessions[chunk.SourceAddr] = sess
+			}
+			// UDPは届かなくてもよいので、バックエンドが受け取れないデータグラムは捨てる
+			if backend.RequestFlowControl && len(chunk.Data) != 0 && !sess.conn.TryAcquireBody() {
+				log.Debug().Str("connection_id", sess.conn.ID.String()).Msg("drop datagram")
+				continue
+			}
+			if err := backend.SendBody(ctx, &remote.BodyChunk{ConnectionId: sess.conn.ID.String(), Data: chunk.Data}); err != nil {
+				return err
+			}
+		case chunk := <-outgoing:
+			if err := stream.Send(&remote.FrontendFrame{
+				Frame: &remote.FrontendFrame_Body{Body: chunk},
+			}); err != nil {
+				return err
+			}
+		case sess := <-ended:
+			if sessions[sess.sourceAddr] == sess {
+				delete(sessions, sess.sourceAddr)
+			}
+			s.registry.Close(sess.conn.ID)
+		case err := <-recvErr:
+			return err
+		case <-backend.Done():
+			return status.Error(codes.Unavailable, registry.ErrBackendClosed.Error())
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// openUDPSession はバックエンドに新しいセッションを作り、バックエンドから返ってくるデータグラムをoutgoingに流す
+// バックエンドがセッションを閉じたらendedに渡す
+func (s *RelayServer) openUDPSession(
+	ctx context.Context,
+	backend *registry.Backend,
+	request *remote.HttpRequestWrapper,
+	sourceAddr string,
+	outgoing chan<- *remote.BodyChunk,
+	ended chan<- *udpSession,
+) (*udpSession, error) {
+	id := registry.ConnectionID(uuid.New().String())
+	conn, err := s.registry.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	sess := &udpSession{sourceAddr: sourceAddr, conn: conn}
+	if err := s.send(ctx, backend, &remote.HttpRequestWrapper{
+		ConnectionId: id.String(),
+		Domain:       request.Domain,
+		StreamBody:   true,
+		Protocol:     remote.Protocol_PROTOCOL_UDP,
+	}); err != nil {
+		s.registry.Close(id)
+		return nil, err
+	}
+	log.Debug().Str("connection_id", id.String()).Str("source_addr", sourceAddr).Msg("udp session is opened")
+
+	go func() {
+		defer func() {
+			select {
+			case ended <- sess:
+			case <-ctx.Done():
+			}
+		}()
+
+		select {
+		case response := <-conn.Responses():
+			if response.Status != http.StatusOK {
+				log.Warn().Int32("status", response.Status).Str("connection_id", id.String()).Msg("failed to open udp session")
+				return
+			}
+		case <-conn.Done():
+			return
+		case <-ctx.Done():
+			return
+		}
+
+		window := newBodyWindow(backend, id)
+		for {
+			select {
+			case chunk := <-conn.Bodies():
+				if chunk.Eof {
+					log.Debug().Str("connection_id", id.String()).Str("source_addr", sourceAddr).Msg("udp session is closed")
+					return
+				}
+				chunk.SourceAddr = sourceAddr
+				select {
+				case outgoing <- chunk:
+				case <-ctx.Done():
+					return
+				}
+				window.consume(ctx)
+			case <-conn.Done():
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return sess, nil
+}
@@ -2,15 +2,36 @@ package config
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/ieee0824/getenv"
 )
 
 type BackendConnecterConfig struct {
 	RelayServerConfig
-	BackendHostName string
-	Scheme          string
-	DeveloperName   string
+	BackendHostName      string
+	Scheme               string
+	DeveloperName        string
+	ReconnectMinInterval time.Duration
+	ReconnectMaxInterval time.Duration
+	// 同時に処理するリクエストの数
+	Concurrency       int
+	HeartbeatInterval time.Duration
+	// http, tcp または udp. tcpとudpの場合はBackendHostNameにバイト列をそのまま流す
+	Protocol string
+	// udpの場合にこの時間データグラムのやり取りがなければセッションを閉じる
+	UDPIdleTimeout time.Duration
+	// relayで同じドメインの他のバックエンドと振り分ける割合
+	Weight int
+	// パスとヘッダーでリクエストを振り分けるローカルのバックエンド. どれにも当てはまらない場合はドメインのバックエンドに送る
+	// Domainsを設定する場合はどのドメインのものかを書く. 書式はParseRoutesを参照
+	Routes string
+	// 1つの接続で登録するドメインとローカルのバックエンドの組. 空の場合はBackendHostNameだけを登録する
+	// 書式はParseDomainsを参照
+	Domains string
+	// relayに登録するときに送るトークン
+	Token string
 }
 
 func NewBackendConnecterConfig() *BackendConnecterConfig {
@@ -19,9 +40,19 @@ func NewBackendConnecterConfig() *BackendConnecterConfig {
 			Host: getenv.String("RELAY_SERVER_HOST"),
 			Port: getenv.String("RELAY_SERVER_PORT", "20000"),
 		},
-		BackendHostName: getenv.String("BACKEND_HOST_NAME"),
-		Scheme:          getenv.String("BACKEND_SCHEME", "http"),
-		DeveloperName:   getenv.String("DEVELOPER_NAME"),
+		BackendHostName:      getenv.String("BACKEND_HOST_NAME"),
+		Scheme:               getenv.String("BACKEND_SCHEME", "http"),
+		DeveloperName:        getenv.String("DEVELOPER_NAME"),
+		ReconnectMinInterval: getenv.Duration("RECONNECT_MIN_INTERVAL", "1s"),
+		ReconnectMaxInterval: getenv.Duration("RECONNECT_MAX_INTERVAL", "30s"),
+		Concurrency:          getenv.Int("CONCURRENCY", 8),
+		HeartbeatInterval:    getenv.Duration("HEARTBEAT_INTERVAL", "10s"),
+		Protocol:             getenv.String("BACKEND_PROTOCOL", "http"),
+		UDPIdleTimeout:       getenv.Duration("UDP_IDLE_TIMEOUT", "60s"),
+		Weight:               getenv.Int("WEIGHT", 1),
+		Routes:               getenv.String("ROUTES"),
+		Domains:              getenv.String("DOMAINS"),
+		Token:                getenv.String("RELAY_TOKEN"),
 	}
 }
 
@@ -41,27 +72,153 @@ func (r *RelayServerConfig) Addr() string {
 	return fmt.Sprintf("%s:%s", r.Host, r.Port)
 }
 
+// RelayConfig はrelay自身の設定
+type RelayConfig struct {
+	RelayServerConfig
+	// backend-connecterからこの時間フレームが届かなければ切断する
+	HeartbeatTimeout time.Duration
+	// 管理画面を返すポート. 空の場合は返さない
+	AdminPort string
+	// ADMIN_TOKENを設定しない場合は認証しないので、既定ではローカルからだけ開けるようにする
+	AdminHost string
+	// 設定されている場合は管理画面のAPIにこのトークンを求める
+	AdminToken string
+	// Drainしたドメインの処理中のリクエストを待つ時間
+	DrainTimeout time.Duration
+	// 登録済みのドメインに別のバックエンドが登録しようとしたときの扱い
+	// takeover, reject または pool
+	RegistrationPolicy string
+	// poolの場合に同じドメインのバックエンドに振り分ける方法
+	// round-robin, least-in-flight または weighted
+	LoadBalancing string
+	// 同じバックエンドにリクエストを送り続けるための目印. cookie または header. 空の場合は使わない
+	StickySession    string
+	StickyCookieName string
+	StickyHeaderName string
+	// 設定されている場合はDeveloperNameからこのドメインの下のサブドメインを割り当てる
+	BaseDomain string
+	// 設定されている場合はこのファイルのトークンを持つバックエンドだけを登録する
+	TokensFile string
+}
+
+func (c *RelayConfig) AdminAddr() string {
+	return fmt.Sprintf("%s:%s", c.AdminHost, c.AdminPort)
+}
+
+func NewRelayConfig() *RelayConfig {
+	return &RelayConfig{
+		RelayServerConfig:  *NewRelayServerConfig(),
+		HeartbeatTimeout:   getenv.Duration("HEARTBEAT_TIMEOUT", "30s"),
+		AdminPort:          getenv.String("ADMIN_PORT"),
+		AdminHost:          getenv.String("ADMIN_HOST", "127.0.0.1"),
+		AdminToken:         getenv.String("ADMIN_TOKEN"),
+		DrainTimeout:       getenv.Duration("DRAIN_TIMEOUT", "30s"),
+		RegistrationPolicy: getenv.String("REGISTRATION_POLICY", "takeover"),
+		LoadBalancing:      getenv.String("LOAD_BALANCING", "round-robin"),
+		StickySession:      getenv.String("STICKY_SESSION"),
+		StickyCookieName:   getenv.String("STICKY_COOKIE_NAME", "vnp_backend"),
+		StickyHeaderName:   getenv.String("STICKY_HEADER_NAME", "X-Vnp-Backend"),
+		BaseDomain:         getenv.String("BASE_DOMAIN"),
+		TokensFile:         getenv.String("TOKENS_FILE"),
+	}
+}
+
 type ClientConfig struct {
 	RelayServerConfig
-	ProxyPort          string
+	ProxyPort string
+	// どこへでも転送するプロキシなので、既定ではローカルからだけ使えるようにする
+	ProxyHost          string
 	EnableTLS          bool
 	SslCertFileName    string
 	SslCertKeyFileName string
+	RequestTimeout     time.Duration
+	// ローカルで待ち受けてrelayにTCPを流すポートとドメインの組
+	// 例: 15432=db.example.com:5432,16379=redis.example.com:6379
+	TCPForwards string
+	// ローカルで待ち受けてrelayにUDPを流すポートとドメインの組
+	UDPForwards string
+	// SOCKS5で待ち受けるポート. 空の場合は待ち受けない
+	SocksPort string
+	// 認証せずにどこへでも転送するので、既定ではローカルからだけ使えるようにする
+	SocksHost string
+	// DNSで待ち受けるポート. 空の場合は待ち受けない
+	DNSPort string
+	// 登録されていないドメインを問い合わせるDNSサーバー
+	DNSUpstream string
+	// 登録されたドメインのA/AAAAに返すこのclientのアドレス. 空の場合は返さない
+	DNSAnswerIPv4 string
+	DNSAnswerIPv6 string
+	// PACファイルを返すポート. 空の場合は返さない
+	PACPort string
+	// PACファイルでブラウザに使わせるこのclientのhost:port
+	PACProxyAddr string
+}
+
+// Forward はclientで待ち受けるポートと転送先のドメインの組
+type Forward struct {
+	Port   string
+	Domain string
+}
+
+func (f Forward) Addr() string {
+	return fmt.Sprintf(":%s", f.Port)
+}
+
+// ParseForwards は port=domain をカンマで区切った設定を読む
+func ParseForwards(s string) ([]Forward, error) {
+	var forwards []Forward
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid forward: %q", entry)
+		}
+		forwards = append(forwards, Forward{Port: kv[0], Domain: kv[1]})
+	}
+	return forwards, nil
 }
 
 func (c *ClientConfig) Addr() string {
-	return fmt.Sprintf(":%s", c.ProxyPort)
+	return fmt.Sprintf("%s:%s", c.ProxyHost, c.ProxyPort)
+}
+
+func (c *ClientConfig) SocksAddr() string {
+	return fmt.Sprintf("%s:%s", c.SocksHost, c.SocksPort)
+}
+
+func (c *ClientConfig) DNSAddr() string {
+	return fmt.Sprintf(":%s", c.DNSPort)
+}
+
+func (c *ClientConfig) PACAddr() string {
+	return fmt.Sprintf(":%s", c.PACPort)
 }
 
 func NewClientConfig() *ClientConfig {
-	return &ClientConfig{
+	c := &ClientConfig{
 		RelayServerConfig: RelayServerConfig{
 			Host: getenv.String("RELAY_SERVER_HOST"),
 			Port: getenv.String("RELAY_SERVER_PORT"),
 		},
 		EnableTLS:          getenv.Bool("ENABLE_TLS"),
 		ProxyPort:          getenv.String("PROXY_PORT"),
+		ProxyHost:          getenv.String("PROXY_HOST", "127.0.0.1"),
 		SslCertFileName:    getenv.String("SSL_CERT_FILE_NAME"),
 		SslCertKeyFileName: getenv.String("SSL_CERT_KEY_FILE_NAME"),
+		RequestTimeout:     getenv.Duration("REQUEST_TIMEOUT", "30s"),
+		TCPForwards:        getenv.String("TCP_FORWARDS"),
+		UDPForwards:        getenv.String("UDP_FORWARDS"),
+		SocksPort:          getenv.String("SOCKS_PORT"),
+		SocksHost:          getenv.String("SOCKS_HOST", "127.0.0.1"),
+		DNSPort:            getenv.String("DNS_PORT"),
+		DNSUpstream:        getenv.String("DNS_UPSTREAM", "8.8.8.8:53"),
+		DNSAnswerIPv4:      getenv.String("DNS_ANSWER_IPV4", "127.0.0.1"),
+		DNSAnswerIPv6:      getenv.String("DNS_ANSWER_IPV6", "::1"),
+		PACPort:            getenv.String("PAC_PORT"),
 	}
+	c.PACProxyAddr = getenv.String("PAC_PROXY_ADDR", "127.0.0.1:"+c.ProxyPort)
+	return c
 }
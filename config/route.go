@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Route はパスの前方一致とヘッダーでリクエストを振り分けるローカルのバックエンド
+type Route struct {
+	// 振り分けるリクエストのドメイン. 空の場合はBackendHostNameのリクエストだけを振り分ける
+	Domain     string
+	PathPrefix string
+	// 空の場合はヘッダーを見ない
+	HeaderName  string
+	HeaderValue string
+	// trueの場合はバックエンドに送るパスからPathPrefixを除く
+	StripPrefix bool
+	Scheme      string
+	Host        string
+}
+
+// ParseRoutes は [domain]prefix=upstream[;strip][;header=Name:Value] をカンマで区切った設定を読む
+// upstreamにスキームがない場合はdefaultSchemeを使う
+// 例: /api=localhost:3000;strip,/=localhost:8080,/v2=https://localhost:3443;header=X-Version:2,web.alice.test/api=localhost:3001
+func ParseRoutes(s string, defaultScheme string) ([]Route, error) {
+	var routes []Route
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		opts := strings.Split(entry, ";")
+		kv := strings.SplitN(opts[0], "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid route: %q", entry)
+		}
+		i := strings.Index(kv[0], "/")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid route: %q", entry)
+		}
+
+		r := Route{Domain: kv[0][:i], PathPrefix: kv[0][i:]}
+		r.Scheme, r.Host = parseUpstream(kv[1], defaultScheme)
+		if r.Host == "" {
+			return nil, fmt.Errorf("invalid route: %q", entry)
+		}
+
+		for _, opt := range opts[1:] {
+			switch {
+			case opt == "strip":
+				r.StripPrefix = true
+			case strings.HasPrefix(opt, "header="):
+				header := strings.SplitN(strings.TrimPrefix(opt, "header="), ":", 2)
+				if len(header) != 2 || header[0] == "" {
+					return nil, fmt.Errorf("invalid route header: %q", entry)
+				}
+				r.HeaderName, r.HeaderValue = header[0], header[1]
+			default:
+				return nil, fmt.Errorf("invalid route option %q: %q", opt, entry)
+			}
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// DomainUpstream は登録するドメインとリクエストを送るローカルのバックエンドの組
+type DomainUpstream struct {
+	Domain string
+	// http, https, tcp または udp
+	Scheme string
+	Host   string
+}
+
+// ParseDomains は domain=upstream をカンマで区切った設定を読む
+// upstreamにスキームがない場合はdefaultSchemeを使う. tcp://とudp://の場合はバイト列をそのまま流す
+// 例: web.alice.test=localhost:8080,api.alice.test=https://localhost:3443,db.alice.test=tcp://localhost:5432
+func ParseDomains(s string, defaultScheme string) ([]DomainUpstream, error) {
+	var domains []DomainUpstream
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid domain: %q", entry)
+		}
+		d := DomainUpstream{Domain: kv[0]}
+		d.Scheme, d.Host = parseUpstream(kv[1], defaultScheme)
+		if d.Host == "" {
+			return nil, fmt.Errorf("invalid domain: %q", entry)
+		}
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+// parseUpstream は [scheme://]host:port をスキームとhost:portに分ける
+func parseUpstream(s string, defaultScheme string) (scheme, host string) {
+	if i := strings.Index(s, "://"); i >= 0 {
+		return s[:i], s[i+len("://"):]
+	}
+	return defaultScheme, s
+}
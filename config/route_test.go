@@ -0,0 +1,117 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []Route
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			in:   "",
+		},
+		{
+			name: "default scheme",
+			in:   "/api=localhost:3000",
+			want: []Route{{PathPrefix: "/api", Scheme: "http", Host: "localhost:3000"}},
+		},
+		{
+			name: "explicit scheme",
+			in:   "/v2=https://localhost:3443",
+			want: []Route{{PathPrefix: "/v2", Scheme: "https", Host: "localhost:3443"}},
+		},
+		{
+			name: "strip and header",
+			in:   "/api=localhost:3000;strip;header=X-Version:2",
+			want: []Route{{
+				PathPrefix:  "/api",
+				HeaderName:  "X-Version",
+				HeaderValue: "2",
+				StripPrefix: true,
+				Scheme:      "http",
+				Host:        "localhost:3000",
+			}},
+		},
+		{
+			name: "header value with colon",
+			in:   "/=localhost:8080;header=X-Target:a:b",
+			want: []Route{{PathPrefix: "/", HeaderName: "X-Target", HeaderValue: "a:b", Scheme: "http", Host: "localhost:8080"}},
+		},
+		{
+			name: "keeps order and skips blank entries",
+			in:   " /=localhost:8080, ,/api/=localhost:3000 ",
+			want: []Route{
+				{PathPrefix: "/", Scheme: "http", Host: "localhost:8080"},
+				{PathPrefix: "/api/", Scheme: "http", Host: "localhost:3000"},
+			},
+		},
+		{
+			name: "domain",
+			in:   "web.alice.test/api=localhost:3001;strip",
+			want: []Route{{Domain: "web.alice.test", PathPrefix: "/api", StripPrefix: true, Scheme: "http", Host: "localhost:3001"}},
+		},
+		{name: "no upstream", in: "/api=", wantErr: true},
+		{name: "no equal sign", in: "/api", wantErr: true},
+		{name: "relative prefix", in: "api=localhost:3000", wantErr: true},
+		{name: "scheme without host", in: "/api=http://", wantErr: true},
+		{name: "header without value separator", in: "/api=localhost:3000;header=X-Version", wantErr: true},
+		{name: "header without name", in: "/api=localhost:3000;header=:2", wantErr: true},
+		{name: "unknown option", in: "/api=localhost:3000;rewrite", wantErr: true},
+		{name: "one bad entry fails all", in: "/=localhost:8080,/api", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRoutes(tt.in, "http")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDomains(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []DomainUpstream
+		wantErr bool
+	}{
+		{
+			name: "default scheme",
+			in:   "web.alice.test=localhost:8080",
+			want: []DomainUpstream{{Domain: "web.alice.test", Scheme: "http", Host: "localhost:8080"}},
+		},
+		{
+			name: "several with schemes",
+			in:   "api.alice.test=https://localhost:3443, db.alice.test=tcp://localhost:5432",
+			want: []DomainUpstream{
+				{Domain: "api.alice.test", Scheme: "https", Host: "localhost:3443"},
+				{Domain: "db.alice.test", Scheme: "tcp", Host: "localhost:5432"},
+			},
+		},
+		{name: "no domain", in: "=localhost:8080", wantErr: true},
+		{name: "no upstream", in: "web.alice.test=", wantErr: true},
+		{name: "scheme without host", in: "web.alice.test=udp://", wantErr: true},
+		{name: "no equal sign", in: "web.alice.test", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDomains(tt.in, "http")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
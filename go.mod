@@ -8,7 +8,7 @@ require (
 	github.com/google/uuid v1.1.2
 	github.com/ieee0824/getenv v0.0.0-20170818064613-c0967c2a6018
 	github.com/rs/zerolog v1.20.0
-	golang.org/x/net v0.0.0-20201224014010-6772e930b67b // indirect
+	golang.org/x/net v0.0.0-20201224014010-6772e930b67b
 	golang.org/x/sys v0.0.0-20201223074533-0d417f636930 // indirect
 	golang.org/x/text v0.3.4 // indirect
 	google.golang.org/genproto v0.0.0-20201214200347-8c77b98c765d // indirect
@@ -0,0 +1,201 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+)
+
+// BackendOptions はバックエンドを登録するときの情報
+type BackendOptions struct {
+	Domain        Domain
+	DeveloperName string
+	// TunnelでつながっていてBodyChunkをやり取りできる
+	Streaming bool
+	Protocol  remote.Protocol
+	// backend-connecterのアドレス
+	RemoteAddr string
+	// weightedで振り分ける割合. 1より小さい場合は1として扱う
+	Weight int
+	// trueの場合、バックエンドは受け取ったリクエストのボディの数を伝えてくる
+	// 伝えられた分を超えてボディを渡さない
+	RequestFlowControl bool
+}
+
+// Window はフロントが受け取ったレスポンスのボディの数
+// バックエンドは受け取られた分だけ続きのボディを送る
+type Window struct {
+	ConnectionID ConnectionID
+	Size         int
+}
+
+// Backend はrelayに接続してきたバックエンド1つを表す
+type Backend struct {
+	// atomicで読み書きするので32bit環境でも揃うように先頭に置く
+	inFlight int64
+
+	ID            string
+	Domain        Domain
+	DeveloperName string
+	Streaming     bool
+	Protocol      remote.Protocol
+	RemoteAddr    string
+	ConnectedAt   time.Time
+	Weight        int
+	// スティッキーセッションでフロントに渡す目印
+	// IDはBackendSendに使えるので、フロントに見せるものは別にする
+	Affinity string
+	// Connection.AcquireBodyでバックエンドが受け取れる分だけリクエストのボディを渡す
+	RequestFlowControl bool
+
+	requests  chan *remote.HttpRequestWrapper
+	bodies    chan *remote.BodyChunk
+	cancels   chan ConnectionID
+	windows   chan Window
+	done      chan struct{}
+	closeOnce sync.Once
+	reason    string
+}
+
+func newBackend(opts BackendOptions) *Backend {
+	weight := opts.Weight
+	if weight < 1 {
+		weight = 1
+	}
+	return &Backend{
+		ID:                 uuid.New().String(),
+		Affinity:           uuid.New().String(),
+		Domain:             opts.Domain,
+		DeveloperName:      opts.DeveloperName,
+		Streaming:          opts.Streaming,
+		Protocol:           opts.Protocol,
+		RemoteAddr:         opts.RemoteAddr,
+		ConnectedAt:        time.Now(),
+		Weight:             weight,
+		RequestFlowControl: opts.RequestFlowControl,
+		requests:           make(chan *remote.HttpRequestWrapper),
+		bodies:             make(chan *remote.BodyChunk),
+		cancels:            make(chan ConnectionID, 64),
+		windows:            make(chan Window),
+		done:               make(chan struct{}),
+	}
+}
+
+// Requests はバックエンドに流すリクエストを返す
+func (b *Backend) Requests() <-chan *remote.HttpRequestWrapper {
+	return b.requests
+}
+
+// Bodies はバックエンドに流すリクエストのボディを返す
+func (b *Backend) Bodies() <-chan *remote.BodyChunk {
+	return b.bodies
+}
+
+// Cancels は取り消されたリクエストのConnectionIDを返す
+func (b *Backend) Cancels() <-chan ConnectionID {
+	return b.cancels
+}
+
+// Windows はフロントが受け取ったレスポンスのボディの数を返す
+func (b *Backend) Windows() <-chan Window {
+	return b.windows
+}
+
+// Cancel は処理中のリクエストの取り消しをバックエンドに伝える
+// 取り消しを受け取れないバックエンドもあるので詰まっている場合は捨てる
+func (b *Backend) Cancel(id ConnectionID) {
+	select {
+	case b.cancels <- id:
+	case <-b.done:
+	default:
+	}
+}
+
+// Begin はリクエストの処理が始まったことを記録する
+// 返した関数を処理が終わったときに呼ぶ
+func (b *Backend) Begin() func() {
+	atomic.AddInt64(&b.inFlight, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&b.inFlight, -1)
+		})
+	}
+}
+
+// InFlight は処理中のリクエストの数を返す
+func (b *Backend) InFlight() int64 {
+	return atomic.LoadInt64(&b.inFlight)
+}
+
+// Done は登録が解除されるとcloseされる
+func (b *Backend) Done() <-chan struct{} {
+	return b.done
+}
+
+// Send はリクエストをバックエンドに渡す
+// 登録が解除されている場合はErrBackendClosedを返す
+func (b *Backend) Send(ctx context.Context, request *remote.HttpRequestWrapper) error {
+	select {
+	case b.requests <- request:
+		return nil
+	case <-b.done:
+		return ErrBackendClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SendBody はリクエストのボディをバックエンドに渡す
+func (b *Backend) SendBody(ctx context.Context, chunk *remote.BodyChunk) error {
+	select {
+	case b.bodies <- chunk:
+		return nil
+	case <-b.done:
+		return ErrBackendClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Backend) closed() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Reason は登録が解除された理由を返す. Doneがcloseされた後に呼ぶ
+func (b *Backend) Reason() string {
+	return b.reason
+}
+
+// close は初めて呼ばれたときだけtrueを返す
+func (b *Backend) close(reason string) bool {
+	closed := false
+	b.closeOnce.Do(func() {
+		b.reason = reason
+		close(b.done)
+		closed = true
+	})
+	return closed
+}
+
+// Grant はフロントがレスポンスのボディをn個受け取ったことをバックエンドに伝える
+// 伝え損なうとバックエンドが続きを送れなくなるので捨てずに待つ
+func (b *Backend) Grant(ctx context.Context, id ConnectionID, n int) error {
+	select {
+	case b.windows <- Window{ConnectionID: id, Size: n}:
+		return nil
+	case <-b.done:
+		return ErrBackendClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
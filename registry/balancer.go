@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Balancer は同じドメインに登録された複数のバックエンドから1つを選ぶ
+// backendsは1つ以上あり、登録された順に並んでいる
+type Balancer interface {
+	Pick(backends []*Backend) *Backend
+}
+
+// ParseBalancer は設定の文字列からBalancerを返す
+// round-robin, least-in-flight または weighted
+func ParseBalancer(s string) (Balancer, error) {
+	switch s {
+	case "round-robin":
+		return &roundRobin{}, nil
+	case "least-in-flight":
+		return leastInFlight{}, nil
+	case "weighted":
+		return &weighted{}, nil
+	}
+	return nil, fmt.Errorf("unknown load balancing strategy: %q", s)
+}
+
+// roundRobin は順番に選ぶ
+type roundRobin struct {
+	next uint64
+}
+
+func (b *roundRobin) Pick(backends []*Backend) *Backend {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return backends[n%uint64(len(backends))]
+}
+
+// leastInFlight は処理中のリクエストが一番少ないものを選ぶ. 同じ数なら先に登録されたものを選ぶ
+type leastInFlight struct{}
+
+func (leastInFlight) Pick(backends []*Backend) *Backend {
+	picked := backends[0]
+	for _, b := range backends[1:] {
+		if b.InFlight() < picked.InFlight() {
+			picked = b
+		}
+	}
+	return picked
+}
+
+// weighted はWeightの割合で順番に選ぶ
+type weighted struct {
+	next uint64
+}
+
+func (b *weighted) Pick(backends []*Backend) *Backend {
+	total := uint64(0)
+	for _, backend := range backends {
+		total += uint64(backend.Weight)
+	}
+	n := (atomic.AddUint64(&b.next, 1) - 1) % total
+	for _, backend := range backends {
+		if n < uint64(backend.Weight) {
+			return backend
+		}
+		n -= uint64(backend.Weight)
+	}
+	return backends[len(backends)-1]
+}
@@ -0,0 +1,146 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseBalancer(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Balancer
+		wantErr bool
+	}{
+		{in: "round-robin", want: &roundRobin{}},
+		{in: "least-in-flight", want: leastInFlight{}},
+		{in: "weighted", want: &weighted{}},
+		{in: "", wantErr: true},
+		{in: "random", wantErr: true},
+		{in: "Round-Robin", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBalancer(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBalancer(%q): err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && fmt.Sprintf("%T", got) != fmt.Sprintf("%T", tt.want) {
+			t.Errorf("ParseBalancer(%q) = %T, want %T", tt.in, got, tt.want)
+		}
+	}
+}
+
+func newTestBackends(weights ...int) []*Backend {
+	backends := make([]*Backend, 0, len(weights))
+	for _, w := range weights {
+		backends = append(backends, newBackend(BackendOptions{Domain: "alice.test", Weight: w}))
+	}
+	return backends
+}
+
+// indexOf はbackendsの中で何番目かを返す
+func indexOf(backends []*Backend, b *Backend) int {
+	for i, backend := range backends {
+		if backend == b {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestRoundRobin(t *testing.T) {
+	backends := newTestBackends(1, 1, 1)
+	b := &roundRobin{}
+	want := []int{0, 1, 2, 0, 1, 2, 0}
+	for i, w := range want {
+		if got := indexOf(backends, b.Pick(backends)); got != w {
+			t.Errorf("pick %d: got %d, want %d", i, got, w)
+		}
+	}
+}
+
+func TestLeastInFlight(t *testing.T) {
+	backends := newTestBackends(1, 1, 1)
+	b := leastInFlight{}
+
+	// 全て同じ数なら先に登録されたもの
+	if got := indexOf(backends, b.Pick(backends)); got != 0 {
+		t.Errorf("idle: got %d, want 0", got)
+	}
+
+	end0 := backends[0].Begin()
+	backends[0].Begin()
+	end1 := backends[1].Begin()
+	if got := indexOf(backends, b.Pick(backends)); got != 2 {
+		t.Errorf("busy 2,1,0: got %d, want 2", got)
+	}
+
+	backends[2].Begin()
+	backends[2].Begin()
+	end1()
+	if got := indexOf(backends, b.Pick(backends)); got != 1 {
+		t.Errorf("busy 2,0,2: got %d, want 1", got)
+	}
+
+	// 終わった処理を2回数えない
+	end0()
+	end0()
+	if n := backends[0].InFlight(); n != 1 {
+		t.Errorf("in flight after double end: got %d, want 1", n)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		want    []int
+	}{
+		{name: "equal", weights: []int{1, 1}, want: []int{3, 3}},
+		{name: "three to one", weights: []int{3, 1}, want: []int{6, 2}},
+		{name: "zero is one", weights: []int{0, 2}, want: []int{2, 4}},
+		{name: "single", weights: []int{5}, want: []int{5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backends := newTestBackends(tt.weights...)
+			total := 0
+			for _, w := range tt.want {
+				total += w
+			}
+			b := &weighted{}
+			got := make([]int, len(backends))
+			for i := 0; i < total; i++ {
+				got[indexOf(backends, b.Pick(backends))]++
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("picks: got %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+// Balancerを指定しない場合は順番に選ぶ
+func TestLookupBalancer(t *testing.T) {
+	r := New(Options{Policy: PolicyPool})
+	var backends []*Backend
+	for _, name := range []string{"alice", "bob"} {
+		b, err := r.Register(BackendOptions{Domain: "alice.test", DeveloperName: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		backends = append(backends, b)
+	}
+	for i := 0; i < 4; i++ {
+		b, ok := r.Lookup("alice.test")
+		if !ok {
+			t.Fatal("lookup fails")
+		}
+		if got := indexOf(backends, b); got != i%2 {
+			t.Errorf("lookup %d: got %d, want %d", i, got, i%2)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+)
+
+// Connection はフロントからのリクエスト1つを表す
+type Connection struct {
+	ID ConnectionID
+
+	responses chan *remote.HttpResponseWrapper
+	bodies    chan *remote.BodyChunk
+	// バックエンドが受け取っていないリクエストのBodyChunkの数だけ埋まる
+	window    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnection(id ConnectionID) *Connection {
+	return &Connection{
+		ID:        id,
+		responses: make(chan *remote.HttpResponseWrapper, 1),
+		bodies:    make(chan *remote.BodyChunk, remote.BodyWindow),
+		window:    make(chan struct{}, remote.BodyWindow),
+		done:      make(chan struct{}),
+	}
+}
+
+// Responses はバックエンドからのレスポンスを返す
+func (c *Connection) Responses() <-chan *remote.HttpResponseWrapper {
+	return c.responses
+}
+
+// Bodies はバックエンドからのレスポンスのボディを返す
+func (c *Connection) Bodies() <-chan *remote.BodyChunk {
+	return c.bodies
+}
+
+// AcquireBody はリクエストのボディを1つバックエンドに渡す前に呼び、バックエンドが受け取れるようになるまで待つ
+func (c *Connection) AcquireBody(ctx context.Context) error {
+	select {
+	case c.window <- struct{}{}:
+		return nil
+	case <-c.done:
+		return ErrConnectionNotFound
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryAcquireBody はAcquireBodyと同じだが、バックエンドが受け取れない場合は待たずにfalseを返す
+func (c *Connection) TryAcquireBody() bool {
+	select {
+	case c.window <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Connection) releaseBody(n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-c.window:
+		default:
+			return
+		}
+	}
+}
+
+// Done はフロントとのやり取りが終わるか、ボディを溜めきれなくなるとcloseされる
+func (c *Connection) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Connection) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
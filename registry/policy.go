@@ -0,0 +1,44 @@
+package registry
+
+import "fmt"
+
+// Policy は既に登録されているドメインに別のバックエンドが登録しようとしたときの扱い
+type Policy int
+
+const (
+	// PolicyTakeover は新しいバックエンドに置き換え、前のバックエンドには奪われたことを伝える
+	PolicyTakeover Policy = iota
+	// PolicyReject は別の開発者の登録を断る. 同じ開発者の再接続は置き換える
+	PolicyReject
+	// PolicyPool は両方を登録してリクエストを振り分ける
+	PolicyPool
+)
+
+func (p Policy) String() string {
+	switch p {
+	case PolicyTakeover:
+		return "takeover"
+	case PolicyReject:
+		return "reject"
+	case PolicyPool:
+		return "pool"
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// ParsePolicy は設定の文字列からPolicyを返す
+func ParsePolicy(s string) (Policy, error) {
+	for _, p := range []Policy{PolicyTakeover, PolicyReject, PolicyPool} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown registration policy: %q", s)
+}
+
+// Options はRegistryの設定
+type Options struct {
+	Policy Policy
+	// PolicyPoolで同じドメインのバックエンドを選ぶ. nilの場合は順番に選ぶ
+	Balancer Balancer
+}
@@ -0,0 +1,157 @@
+package registry
+
+import "testing"
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Policy
+		wantErr bool
+	}{
+		{in: "takeover", want: PolicyTakeover},
+		{in: "reject", want: PolicyReject},
+		{in: "pool", want: PolicyPool},
+		{in: "", wantErr: true},
+		{in: "Pool", wantErr: true},
+		{in: "share", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePolicy(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePolicy(%q): err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParsePolicy(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		second string
+		// 2つ目の登録の結果
+		wantErr error
+		// 1つ目のバックエンドが解除された理由. 空の場合は解除されない
+		wantReason string
+		wantCount  int
+	}{
+		{
+			name:       "takeover by another developer",
+			policy:     PolicyTakeover,
+			second:     "bob",
+			wantReason: ReasonTakenOver,
+			wantCount:  1,
+		},
+		{
+			name:       "takeover by same developer",
+			policy:     PolicyTakeover,
+			second:     "alice",
+			wantReason: ReasonTakenOver,
+			wantCount:  1,
+		},
+		{
+			name:      "reject another developer",
+			policy:    PolicyReject,
+			second:    "bob",
+			wantErr:   ErrDomainTaken,
+			wantCount: 1,
+		},
+		{
+			name:       "reject replaces same developer",
+			policy:     PolicyReject,
+			second:     "alice",
+			wantReason: ReasonTakenOver,
+			wantCount:  1,
+		},
+		{
+			name:      "pool keeps both",
+			policy:    PolicyPool,
+			second:    "bob",
+			wantCount: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(Options{Policy: tt.policy})
+			first, err := r.Register(BackendOptions{Domain: "alice.test", DeveloperName: "alice"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := r.Register(BackendOptions{Domain: "alice.test", DeveloperName: tt.second})
+			if err != tt.wantErr {
+				t.Fatalf("register: got %v, want %v", err, tt.wantErr)
+			}
+
+			select {
+			case <-first.Done():
+				if first.Reason() != tt.wantReason {
+					t.Errorf("reason: got %q, want %q", first.Reason(), tt.wantReason)
+				}
+			default:
+				if tt.wantReason != "" {
+					t.Errorf("first backend is not closed, want %q", tt.wantReason)
+				}
+			}
+
+			if got := len(r.Backends()); got != tt.wantCount {
+				t.Errorf("backends: got %d, want %d", got, tt.wantCount)
+			}
+			if _, ok := r.Get(first.ID); ok != (tt.wantReason == "") {
+				t.Errorf("first backend is found: %v", ok)
+			}
+			if second != nil {
+				if _, ok := r.Get(second.ID); !ok {
+					t.Error("second backend is not found")
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterDrained(t *testing.T) {
+	r := New(Options{Policy: PolicyPool})
+	r.Drain("alice.test")
+	if _, err := r.Register(BackendOptions{Domain: "alice.test", DeveloperName: "alice"}); err != ErrDomainDrained {
+		t.Errorf("register drained domain: got %v, want %v", err, ErrDomainDrained)
+	}
+	if _, err := r.Register(BackendOptions{Domain: "bob.test", DeveloperName: "bob"}); err != nil {
+		t.Errorf("register other domain: %v", err)
+	}
+
+	r.Undrain("alice.test")
+	if _, err := r.Register(BackendOptions{Domain: "alice.test", DeveloperName: "alice"}); err != nil {
+		t.Errorf("register undrained domain: %v", err)
+	}
+}
+
+// Unregisterは同じドメインに登録された他のバックエンドを残す
+func TestUnregisterPooled(t *testing.T) {
+	r := New(Options{Policy: PolicyPool})
+	first, err := r.Register(BackendOptions{Domain: "alice.test", DeveloperName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := r.Register(BackendOptions{Domain: "alice.test", DeveloperName: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Unregister(first)
+	if first.Reason() != ReasonUnregistered {
+		t.Errorf("reason: got %q, want %q", first.Reason(), ReasonUnregistered)
+	}
+	for i := 0; i < 3; i++ {
+		b, ok := r.Lookup("alice.test")
+		if !ok || b != second {
+			t.Fatalf("lookup after unregister: got %v, want second backend", b)
+		}
+	}
+	r.Unregister(second)
+	if _, ok := r.Lookup("alice.test"); ok {
+		t.Error("lookup finds unregistered domain")
+	}
+}
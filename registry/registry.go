@@ -0,0 +1,409 @@
+// Package registry はrelayに登録されたバックエンドと処理中のコネクションを管理する
+package registry
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+)
+
+var (
+	ErrDomainNotRegistered = errors.New("domain is not registered")
+	ErrBackendClosed       = errors.New("backend is closed")
+	ErrConnectionExists    = errors.New("connection already exists")
+	ErrConnectionNotFound  = errors.New("connection does not exist")
+	ErrDuplicateResponse   = errors.New("response is already delivered")
+	ErrDomainDrained       = errors.New("domain is drained")
+	ErrDomainTaken         = errors.New("domain is registered by another developer")
+	ErrBodyOverflow        = errors.New("response body overflows the connection buffer")
+)
+
+// バックエンドの登録が解除された理由
+const (
+	ReasonUnregistered = "backend is unregistered"
+	ReasonTakenOver    = "domain is taken over by another backend"
+)
+
+type ConnectionID string
+type Domain string
+
+func (c ConnectionID) String() string {
+	return string(c)
+}
+
+func (d Domain) String() string {
+	return string(d)
+}
+
+type Registry struct {
+	policy   Policy
+	balancer Balancer
+
+	mu sync.RWMutex
+	// 登録された順に並べる
+	backends    map[Domain][]*Backend
+	byID        map[string]*Backend
+	byAffinity  map[string]*Backend
+	connections map[ConnectionID]*Connection
+	// 新しいリクエストも登録も受け付けないドメイン
+	drained map[Domain]struct{}
+
+	hookMu       sync.RWMutex
+	onRegister   []func(*Backend)
+	onUnregister []func(*Backend)
+	watchers     map[chan struct{}]struct{}
+}
+
+func New(opts Options) *Registry {
+	balancer := opts.Balancer
+	if balancer == nil {
+		balancer = &roundRobin{}
+	}
+	return &Registry{
+		policy:      opts.Policy,
+		balancer:    balancer,
+		backends:    map[Domain][]*Backend{},
+		byID:        map[string]*Backend{},
+		byAffinity:  map[string]*Backend{},
+		connections: map[ConnectionID]*Connection{},
+		drained:     map[Domain]struct{}{},
+		watchers:    map[chan struct{}]struct{}{},
+	}
+}
+
+// OnRegister はバックエンドが登録された後に呼ばれる関数を追加する
+func (r *Registry) OnRegister(f func(*Backend)) {
+	r.hookMu.Lock()
+	defer r.hookMu.Unlock()
+	r.onRegister = append(r.onRegister, f)
+}
+
+// OnUnregister はバックエンドの登録が解除された後に呼ばれる関数を追加する
+func (r *Registry) OnUnregister(f func(*Backend)) {
+	r.hookMu.Lock()
+	defer r.hookMu.Unlock()
+	r.onUnregister = append(r.onUnregister, f)
+}
+
+// Watch はバックエンドの登録や解除があるたびに通知するチャンネルを返す
+// 続けて起きた変更は1回の通知にまとめられるので、受け取ったらBackendsで一覧を取り直す
+// 返した関数を呼ぶと通知をやめる
+func (r *Registry) Watch() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	r.hookMu.Lock()
+	r.watchers[ch] = struct{}{}
+	r.hookMu.Unlock()
+	return ch, func() {
+		r.hookMu.Lock()
+		defer r.hookMu.Unlock()
+		delete(r.watchers, ch)
+	}
+}
+
+func (r *Registry) runHooks(hooks []func(*Backend), b *Backend) {
+	for _, f := range hooks {
+		f(b)
+	}
+	r.notify()
+}
+
+// notify はWatchしているものに変更を伝える. hookMuを持って呼ぶ
+func (r *Registry) notify() {
+	for ch := range r.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Register はドメインにバックエンドを登録する
+// 既に登録されているバックエンドがある場合はPolicyに従う
+// Drainされているドメインには登録できない
+func (r *Registry) Register(opts BackendOptions) (*Backend, error) {
+	b := newBackend(opts)
+
+	r.mu.Lock()
+	if _, ok := r.drained[b.Domain]; ok {
+		r.mu.Unlock()
+		return nil, ErrDomainDrained
+	}
+	var replaced []*Backend
+	current := r.backends[b.Domain]
+	switch r.policy {
+	case PolicyPool:
+	case PolicyReject:
+		for _, old := range current {
+			if old.DeveloperName != b.DeveloperName {
+				r.mu.Unlock()
+				return nil, ErrDomainTaken
+			}
+		}
+		replaced = current
+	default:
+		replaced = current
+	}
+	if len(replaced) != 0 {
+		for _, old := range replaced {
+			delete(r.byID, old.ID)
+			delete(r.byAffinity, old.Affinity)
+		}
+		current = nil
+	}
+	r.backends[b.Domain] = append(current, b)
+	r.byID[b.ID] = b
+	r.byAffinity[b.Affinity] = b
+	r.mu.Unlock()
+
+	r.hookMu.RLock()
+	defer r.hookMu.RUnlock()
+	for _, old := range replaced {
+		if old.close(ReasonTakenOver) {
+			r.runHooks(r.onUnregister, old)
+		}
+	}
+	r.runHooks(r.onRegister, b)
+	return b, nil
+}
+
+// Unregister はバックエンドの登録を解除する
+// 同じドメインに登録されている別のバックエンドには影響しない
+func (r *Registry) Unregister(b *Backend) {
+	r.Disconnect(b, ReasonUnregistered)
+}
+
+// Disconnect は理由を付けてバックエンドの登録を解除する
+func (r *Registry) Disconnect(b *Backend, reason string) {
+	r.mu.Lock()
+	current := r.backends[b.Domain]
+	for i, c := range current {
+		if c == b {
+			current = append(current[:i:i], current[i+1:]...)
+			break
+		}
+	}
+	if len(current) == 0 {
+		delete(r.backends, b.Domain)
+	} else {
+		r.backends[b.Domain] = current
+	}
+	delete(r.byID, b.ID)
+	delete(r.byAffinity, b.Affinity)
+	r.mu.Unlock()
+
+	if !b.close(reason) {
+		// 既に解除済み
+		return
+	}
+
+	r.hookMu.RLock()
+	defer r.hookMu.RUnlock()
+	r.runHooks(r.onUnregister, b)
+}
+
+// resolve はリクエストのドメインに当てはまる登録されたドメインを返す
+// ポートが違うものやワイルドカードにも当てはまる. 呼び出し元でmuをロックする
+func (r *Registry) resolve(requested Domain) (Domain, bool) {
+	if _, ok := r.backends[requested]; ok {
+		return requested, true
+	}
+	registered := make([]Domain, 0, len(r.backends))
+	for d := range r.backends {
+		registered = append(registered, d)
+	}
+	return route(registered, requested)
+}
+
+// Lookup はドメインに登録されているバックエンドを返す
+// 複数登録されている場合はBalancerで選ぶ
+// Drainされているドメインは見つからないものとして扱う
+func (r *Registry) Lookup(requested Domain) (*Backend, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	domain, ok := r.resolve(requested)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := r.drained[domain]; ok {
+		return nil, false
+	}
+	alive := make([]*Backend, 0, len(r.backends[domain]))
+	for _, b := range r.backends[domain] {
+		// ストリームが切れたものはUnregisterを待たずに外す
+		if !b.closed() {
+			alive = append(alive, b)
+		}
+	}
+	if len(alive) == 0 {
+		return nil, false
+	}
+	return r.balancer.Pick(alive), true
+}
+
+// Resolve はドメインに届くバックエンドのうち最初に登録されたものを返す
+// Lookupと違ってBalancerを進めないので、ドメインがあるかを確かめるのに使う
+func (r *Registry) Resolve(requested Domain) (*Backend, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	domain, ok := r.resolve(requested)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := r.drained[domain]; ok {
+		return nil, false
+	}
+	for _, b := range r.backends[domain] {
+		if !b.closed() {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// LookupByAffinity はドメインに登録されているバックエンドをスティッキーセッションの目印で探す
+// 解除されたものやDrainされているドメインのものは見つからないものとして扱う
+func (r *Registry) LookupByAffinity(requested Domain, affinity string) (*Backend, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	domain, ok := r.resolve(requested)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := r.drained[domain]; ok {
+		return nil, false
+	}
+	b, ok := r.byAffinity[affinity]
+	if !ok || b.Domain != domain || b.closed() {
+		return nil, false
+	}
+	return b, true
+}
+
+// Drain はドメインへの新しいリクエストと登録を止める
+// 登録されているバックエンドを返すので、処理中のリクエストが終わってから登録を解除する
+func (r *Registry) Drain(domain Domain) []*Backend {
+	r.mu.Lock()
+	r.drained[domain] = struct{}{}
+	backends := append([]*Backend(nil), r.backends[domain]...)
+	r.mu.Unlock()
+
+	r.hookMu.RLock()
+	defer r.hookMu.RUnlock()
+	r.notify()
+	return backends
+}
+
+// Undrain はDrainしたドメインを元に戻す
+func (r *Registry) Undrain(domain Domain) {
+	r.mu.Lock()
+	delete(r.drained, domain)
+	r.mu.Unlock()
+
+	r.hookMu.RLock()
+	defer r.hookMu.RUnlock()
+	r.notify()
+}
+
+// Drained はDrainされているドメインの一覧を返す
+func (r *Registry) Drained() []Domain {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ret := make([]Domain, 0, len(r.drained))
+	for d := range r.drained {
+		ret = append(ret, d)
+	}
+	return ret
+}
+
+// Get はIDでバックエンドを探す
+func (r *Registry) Get(id string) (*Backend, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	b, ok := r.byID[id]
+	return b, ok
+}
+
+// Backends は登録されているバックエンドの一覧を返す. Drainされているものも含む
+func (r *Registry) Backends() []*Backend {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ret := make([]*Backend, 0, len(r.byID))
+	for _, backends := range r.backends {
+		ret = append(ret, backends...)
+	}
+	return ret
+}
+
+// Open はレスポンスの受け口を作る
+func (r *Registry) Open(id ConnectionID) (*Connection, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.connections[id]; ok {
+		return nil, ErrConnectionExists
+	}
+	c := newConnection(id)
+	r.connections[id] = c
+	return c, nil
+}
+
+// Close はレスポンスの受け口を破棄する
+func (r *Registry) Close(id ConnectionID) {
+	r.mu.Lock()
+	c, ok := r.connections[id]
+	delete(r.connections, id)
+	r.mu.Unlock()
+	if ok {
+		c.close()
+	}
+}
+
+func (r *Registry) connection(id ConnectionID) (*Connection, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.connections[id]
+	return c, ok
+}
+
+// Deliver はバックエンドからのレスポンスを待っているフロントに渡す
+func (r *Registry) Deliver(response *remote.HttpResponseWrapper) error {
+	c, ok := r.connection(ConnectionID(response.GetConnectionId()))
+	if !ok {
+		return ErrConnectionNotFound
+	}
+	select {
+	case c.responses <- response:
+		return nil
+	default:
+		return ErrDuplicateResponse
+	}
+}
+
+// ReleaseBody はバックエンドがリクエストのボディをn個受け取ったので、その分だけ続きを渡せるようにする
+func (r *Registry) ReleaseBody(id ConnectionID, n int) {
+	if c, ok := r.connection(id); ok {
+		c.releaseBody(n)
+	}
+}
+
+// DeliverBody はバックエンドからのレスポンスのボディをフロントに渡す
+// 1つのTunnelで全てのコネクションのボディを受け取るので待たない
+// フロントが受け取りきれずに溜まりすぎた場合はそのコネクションだけを閉じてErrBodyOverflowを返す
+func (r *Registry) DeliverBody(chunk *remote.BodyChunk) error {
+	c, ok := r.connection(ConnectionID(chunk.GetConnectionId()))
+	if !ok {
+		return ErrConnectionNotFound
+	}
+	select {
+	case <-c.done:
+		return ErrConnectionNotFound
+	default:
+	}
+	select {
+	case c.bodies <- chunk:
+		return nil
+	default:
+		c.close()
+		return ErrBodyOverflow
+	}
+}
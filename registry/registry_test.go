@@ -0,0 +1,204 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ieee0824/virtual-neighbor-proxy/remote"
+)
+
+// 登録と解除、レスポンスの受け渡しを同時に行っても壊れないことを -race で確かめる
+func TestRegistryConcurrent(t *testing.T) {
+	r := New(Options{Policy: PolicyPool})
+	domains := []Domain{"a.test", "b.test", "*.c.test"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b, err := r.Register(BackendOptions{
+					Domain:        domains[(i+j)%len(domains)],
+					DeveloperName: fmt.Sprintf("dev%d", i),
+					Streaming:     true,
+				})
+				if err != nil {
+					t.Errorf("register: %v", err)
+					return
+				}
+				r.Lookup("a.test")
+				r.Resolve("x.c.test")
+				r.LookupByAffinity(b.Domain, b.Affinity)
+				r.Backends()
+				r.Unregister(b)
+			}
+		}(i)
+	}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id := ConnectionID(fmt.Sprintf("conn-%d-%d", i, j))
+				conn, err := r.Open(id)
+				if err != nil {
+					t.Errorf("open: %v", err)
+					return
+				}
+				go func() {
+					if err := r.Deliver(&remote.HttpResponseWrapper{ConnectionId: id.String(), Status: 200}); err != nil {
+						t.Errorf("deliver: %v", err)
+					}
+					if err := r.DeliverBody(&remote.BodyChunk{ConnectionId: id.String(), Eof: true}); err != nil {
+						t.Errorf("deliver body: %v", err)
+					}
+				}()
+				select {
+				case <-conn.Responses():
+				case <-time.After(5 * time.Second):
+					t.Errorf("response of %s is not delivered", id)
+					return
+				}
+				select {
+				case <-conn.Bodies():
+				case <-time.After(5 * time.Second):
+					t.Errorf("body of %s is not delivered", id)
+					return
+				}
+				r.Close(id)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if backends := r.Backends(); len(backends) != 0 {
+		t.Errorf("backends are left: %d", len(backends))
+	}
+	if err := r.Deliver(&remote.HttpResponseWrapper{ConnectionId: "conn-0-0"}); err != ErrConnectionNotFound {
+		t.Errorf("deliver to closed connection: got %v, want %v", err, ErrConnectionNotFound)
+	}
+}
+
+func TestDeliverDuplicateResponse(t *testing.T) {
+	r := New(Options{})
+	if _, err := r.Open("conn"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Open("conn"); err != ErrConnectionExists {
+		t.Errorf("open twice: got %v, want %v", err, ErrConnectionExists)
+	}
+	if err := r.Deliver(&remote.HttpResponseWrapper{ConnectionId: "conn"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Deliver(&remote.HttpResponseWrapper{ConnectionId: "conn"}); err != ErrDuplicateResponse {
+		t.Errorf("deliver twice: got %v, want %v", err, ErrDuplicateResponse)
+	}
+}
+
+// Resolveは何度呼んでもLookupの振り分けを進めない
+func TestResolveDoesNotPick(t *testing.T) {
+	r := New(Options{Policy: PolicyPool})
+	first, err := r.Register(BackendOptions{Domain: "alice.test", DeveloperName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := r.Register(BackendOptions{Domain: "alice.test", DeveloperName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	picked, _ := r.Lookup("alice.test")
+	for i := 0; i < 3; i++ {
+		b, ok := r.Resolve("alice.test")
+		if !ok || b != first {
+			t.Fatalf("resolve: got %v, want first backend", b)
+		}
+	}
+	next, _ := r.Lookup("alice.test")
+	if next == picked {
+		t.Errorf("lookup after resolve picks the same backend: %s", next.ID)
+	}
+	if next != first && next != second {
+		t.Errorf("lookup picks unknown backend: %s", next.ID)
+	}
+
+	r.Drain("alice.test")
+	if _, ok := r.Resolve("alice.test"); ok {
+		t.Error("resolve finds drained domain")
+	}
+}
+
+// フロントが受け取らなくてもDeliverBodyは待たず、そのコネクションだけを閉じる
+func TestDeliverBodyOverflow(t *testing.T) {
+	r := New(Options{})
+	slow, err := r.Open("slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := r.Open("fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < remote.BodyWindow; i++ {
+		if err := r.DeliverBody(&remote.BodyChunk{ConnectionId: "slow"}); err != nil {
+			t.Fatalf("chunk %d: %v", i, err)
+		}
+	}
+	if err := r.DeliverBody(&remote.BodyChunk{ConnectionId: "slow"}); err != ErrBodyOverflow {
+		t.Fatalf("overflow: got %v, want %v", err, ErrBodyOverflow)
+	}
+	select {
+	case <-slow.Done():
+	default:
+		t.Error("overflowed connection is not closed")
+	}
+	if err := r.DeliverBody(&remote.BodyChunk{ConnectionId: "slow"}); err != ErrConnectionNotFound {
+		t.Errorf("after overflow: got %v, want %v", err, ErrConnectionNotFound)
+	}
+
+	if err := r.DeliverBody(&remote.BodyChunk{ConnectionId: "fast", Eof: true}); err != nil {
+		t.Fatal(err)
+	}
+	if chunk := <-fast.Bodies(); !chunk.Eof {
+		t.Error("other connection does not receive its body")
+	}
+}
+
+func TestAcquireBody(t *testing.T) {
+	r := New(Options{})
+	c, err := r.Open("upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < remote.BodyWindow; i++ {
+		if !c.TryAcquireBody() {
+			t.Fatalf("chunk %d is not acquired", i)
+		}
+	}
+	if c.TryAcquireBody() {
+		t.Fatal("acquire beyond the window")
+	}
+
+	r.ReleaseBody("upload", remote.BodyWindow/2)
+	for i := 0; i < remote.BodyWindow/2; i++ {
+		if err := c.AcquireBody(context.Background()); err != nil {
+			t.Fatalf("chunk %d after release: %v", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.AcquireBody(ctx); err != context.DeadlineExceeded {
+		t.Errorf("full window: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	r.Close("upload")
+	if err := c.AcquireBody(context.Background()); err != ErrConnectionNotFound {
+		t.Errorf("closed connection: got %v, want %v", err, ErrConnectionNotFound)
+	}
+}
@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"net"
+	"strings"
+)
+
+// ワイルドカードで登録するドメインの接頭辞. *.alice.test はalice.testの下の全てのホストに当てはまる
+const wildcardPrefix = "*."
+
+// Wildcard はワイルドカードで登録されたドメインかを返す
+func (d Domain) Wildcard() bool {
+	return strings.HasPrefix(string(d), wildcardPrefix)
+}
+
+// ポートの当てはまり方. 小さいほど優先する
+const (
+	portEqual = iota
+	portAny
+	portDiffer
+)
+
+// priority は登録されたドメインがリクエストのドメインにどれだけ具体的に当てはまるか
+type priority struct {
+	wildcard bool
+	// ワイルドカードの*を除いた部分の長さ
+	suffix int
+	port   int
+}
+
+// less はaがbよりも優先されるかを返す
+// ワイルドカードでないもの、ワイルドカードの中では長いもの、ポートが同じもの、ポートを指定していないものの順に優先する
+func (a priority) less(b priority) bool {
+	if a.wildcard != b.wildcard {
+		return !a.wildcard
+	}
+	if a.suffix != b.suffix {
+		return a.suffix > b.suffix
+	}
+	return a.port < b.port
+}
+
+// splitDomain はドメインを小文字のホスト名とポートに分ける. ポートがない場合は空になる
+func splitDomain(d Domain) (host, port string) {
+	host = string(d)
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	return strings.ToLower(strings.TrimSuffix(host, ".")), port
+}
+
+// match は登録されたドメインがリクエストのホスト名とポートに当てはまるかと、その優先度を返す
+func match(registered Domain, host, port string) (priority, bool) {
+	pattern, patternPort := splitDomain(registered)
+
+	var p priority
+	switch {
+	case Domain(pattern).Wildcard():
+		suffix := pattern[len(wildcardPrefix)-1:]
+		if !strings.HasSuffix(host, suffix) || len(host) == len(suffix) {
+			return p, false
+		}
+		p.wildcard = true
+		p.suffix = len(suffix)
+	case pattern == host:
+		p.suffix = len(pattern)
+	default:
+		return p, false
+	}
+
+	switch patternPort {
+	case port:
+		p.port = portEqual
+	case "":
+		p.port = portAny
+	default:
+		p.port = portDiffer
+	}
+	return p, true
+}
+
+// Match はドメインがpatternに当てはまるかを返す. patternにはワイルドカードを使え、ポートは見ない
+func Match(pattern, domain Domain) bool {
+	host, port := splitDomain(domain)
+	_, ok := match(pattern, host, port)
+	return ok
+}
+
+// route は登録されたドメインの中からリクエストのドメインに最も具体的に当てはまるものを返す
+// 優先度が同じものは文字列の順で選ぶので、どの順に登録されても同じものが選ばれる
+func route(registered []Domain, requested Domain) (Domain, bool) {
+	host, port := splitDomain(requested)
+
+	var (
+		best     Domain
+		bestPrio priority
+		found    bool
+	)
+	for _, d := range registered {
+		p, ok := match(d, host, port)
+		if !ok {
+			continue
+		}
+		if !found || p.less(bestPrio) || (!bestPrio.less(p) && d < best) {
+			best, bestPrio, found = d, p, true
+		}
+	}
+	return best, found
+}
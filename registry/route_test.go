@@ -0,0 +1,204 @@
+package registry
+
+import "testing"
+
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		name       string
+		registered []Domain
+		requested  Domain
+		want       Domain
+		found      bool
+	}{
+		{
+			name:       "exact",
+			registered: []Domain{"alice.test", "bob.test"},
+			requested:  "alice.test",
+			want:       "alice.test",
+			found:      true,
+		},
+		{
+			name:       "not registered",
+			registered: []Domain{"alice.test"},
+			requested:  "bob.test",
+		},
+		{
+			name:       "wildcard",
+			registered: []Domain{"*.alice.test"},
+			requested:  "web.alice.test",
+			want:       "*.alice.test",
+			found:      true,
+		},
+		{
+			name:       "wildcard matches deeper subdomain",
+			registered: []Domain{"*.alice.test"},
+			requested:  "a.b.alice.test",
+			want:       "*.alice.test",
+			found:      true,
+		},
+		{
+			name:       "wildcard does not match bare apex",
+			registered: []Domain{"*.alice.test"},
+			requested:  "alice.test",
+		},
+		{
+			name:       "wildcard does not match partial label",
+			registered: []Domain{"*.alice.test"},
+			requested:  "malice.test",
+		},
+		{
+			name:       "exact wins over wildcard",
+			registered: []Domain{"*.alice.test", "web.alice.test"},
+			requested:  "web.alice.test",
+			want:       "web.alice.test",
+			found:      true,
+		},
+		{
+			name:       "longest wildcard suffix wins",
+			registered: []Domain{"*.test", "*.alice.test", "*.web.alice.test"},
+			requested:  "v1.web.alice.test",
+			want:       "*.web.alice.test",
+			found:      true,
+		},
+		{
+			name:       "shorter wildcard when longer does not match",
+			registered: []Domain{"*.test", "*.web.alice.test"},
+			requested:  "api.alice.test",
+			want:       "*.test",
+			found:      true,
+		},
+		{
+			name:       "equal port wins over no port",
+			registered: []Domain{"alice.test", "alice.test:8080"},
+			requested:  "alice.test:8080",
+			want:       "alice.test:8080",
+			found:      true,
+		},
+		{
+			name:       "no port wins over different port",
+			registered: []Domain{"alice.test:9090", "alice.test"},
+			requested:  "alice.test:8080",
+			want:       "alice.test",
+			found:      true,
+		},
+		{
+			name:       "different port matches when nothing else does",
+			registered: []Domain{"alice.test:9090"},
+			requested:  "alice.test:8080",
+			want:       "alice.test:9090",
+			found:      true,
+		},
+		{
+			name:       "request without port prefers registration without port",
+			registered: []Domain{"alice.test:8080", "alice.test"},
+			requested:  "alice.test",
+			want:       "alice.test",
+			found:      true,
+		},
+		{
+			name:       "wildcard with port",
+			registered: []Domain{"*.alice.test", "*.alice.test:8080"},
+			requested:  "web.alice.test:8080",
+			want:       "*.alice.test:8080",
+			found:      true,
+		},
+		{
+			name:       "suffix length wins over port",
+			registered: []Domain{"*.test:8080", "*.alice.test:9090"},
+			requested:  "web.alice.test:8080",
+			want:       "*.alice.test:9090",
+			found:      true,
+		},
+		{
+			name:       "case insensitive",
+			registered: []Domain{"Alice.Test"},
+			requested:  "alice.TEST",
+			want:       "Alice.Test",
+			found:      true,
+		},
+		{
+			name:       "wildcard case insensitive",
+			registered: []Domain{"*.Alice.test"},
+			requested:  "WEB.alice.test",
+			want:       "*.Alice.test",
+			found:      true,
+		},
+		{
+			name:       "trailing dot in request",
+			registered: []Domain{"alice.test"},
+			requested:  "alice.test.",
+			want:       "alice.test",
+			found:      true,
+		},
+		{
+			name:       "trailing dot in request with port",
+			registered: []Domain{"alice.test:8080"},
+			requested:  "alice.test.:8080",
+			want:       "alice.test:8080",
+			found:      true,
+		},
+		{
+			name:       "trailing dot in registration",
+			registered: []Domain{"*.alice.test."},
+			requested:  "web.alice.test",
+			want:       "*.alice.test.",
+			found:      true,
+		},
+		{
+			name:       "tie is broken by string order",
+			registered: []Domain{"alice.test:9090", "alice.test:8888"},
+			requested:  "alice.test:8080",
+			want:       "alice.test:8888",
+			found:      true,
+		},
+		{
+			name:       "tie between normalised duplicates",
+			registered: []Domain{"alice.test.", "ALICE.test"},
+			requested:  "alice.test",
+			want:       "ALICE.test",
+			found:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 登録された順に依らずに同じものを選ぶ
+			orders := [][]Domain{tt.registered, reversed(tt.registered)}
+			for _, registered := range orders {
+				got, found := route(registered, tt.requested)
+				if got != tt.want || found != tt.found {
+					t.Errorf("route(%v, %q) = %q, %v; want %q, %v", registered, tt.requested, got, found, tt.want, tt.found)
+				}
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern Domain
+		domain  Domain
+		want    bool
+	}{
+		{"alice.test", "alice.test", true},
+		{"alice.test", "alice.test:8080", true},
+		{"alice.test:8080", "alice.test:9090", true},
+		{"alice.test", "bob.test", false},
+		{"*.alice.test", "web.alice.test", true},
+		{"*.alice.test", "alice.test", false},
+		{"*.alice.test", "WEB.Alice.Test.", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.domain); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.domain, got, tt.want)
+		}
+	}
+}
+
+func reversed(ds []Domain) []Domain {
+	ret := make([]Domain, len(ds))
+	for i, d := range ds {
+		ret[len(ds)-1-i] = d
+	}
+	return ret
+}
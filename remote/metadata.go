@@ -0,0 +1,15 @@
+package remote
+
+// gRPCのメタデータで使うキー
+const (
+	// relayがBackendReceiveのヘッダーで払い出すバックエンドのID
+	// backend-connecterはBackendSendのメタデータでこのIDを送り返す
+	MetadataBackendID = "x-backend-id"
+	// relayがBackendReceiveのヘッダーで返す割り当てたドメイン
+	MetadataDomain = "x-domain"
+	// backend-connecterが登録するときに送るトークン. "Bearer <token>" の形で送る
+	MetadataAuthorization = "authorization"
+	// relayがBackendReceiveのトレーラーで返す、別のバックエンドにドメインを奪われたこと
+	// backend-connecterはこれを受け取ったら再接続しない
+	MetadataDisplaced = "x-displaced"
+)
@@ -29,6 +29,125 @@ const (
 // of the legacy proto package is being used.
 const _ = proto.ProtoPackageIsVersion4
 
+// バックエンドが受け付ける通信の種類
+type Protocol int32
+
+const (
+	Protocol_PROTOCOL_HTTP Protocol = 0
+	// 生のTCPのバイト列をBodyChunkでそのまま流す
+	Protocol_PROTOCOL_TCP Protocol = 1
+	// UDPのデータグラムをBodyChunk1つずつに入れて流す
+	Protocol_PROTOCOL_UDP Protocol = 2
+)
+
+// Enum value maps for Protocol.
+var (
+	Protocol_name = map[int32]string{
+		0: "PROTOCOL_HTTP",
+		1: "PROTOCOL_TCP",
+		2: "PROTOCOL_UDP",
+	}
+	Protocol_value = map[string]int32{
+		"PROTOCOL_HTTP": 0,
+		"PROTOCOL_TCP":  1,
+		"PROTOCOL_UDP":  2,
+	}
+)
+
+func (x Protocol) Enum() *Protocol {
+	p := new(Protocol)
+	*p = x
+	return p
+}
+
+func (x Protocol) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Protocol) Descriptor() protoreflect.EnumDescriptor {
+	return file_remote_proto_enumTypes[0].Descriptor()
+}
+
+func (Protocol) Type() protoreflect.EnumType {
+	return &file_remote_proto_enumTypes[0]
+}
+
+func (x Protocol) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Protocol.Descriptor instead.
+func (Protocol) EnumDescriptor() ([]byte, []int) {
+	return file_remote_proto_rawDescGZIP(), []int{0}
+}
+
+type ControlType int32
+
+const (
+	ControlType_CONTROL_UNKNOWN ControlType = 0
+	// relay -> backend-connecter: 登録が完了した
+	ControlType_CONTROL_REGISTERED ControlType = 1
+	// ConnectionIdのリクエストを取り消す
+	// backend-connecter -> relayではリクエストのボディを溜めきれなかったときに送る
+	ControlType_CONTROL_CANCEL ControlType = 2
+	// トンネルを閉じる. Messageに理由を入れる
+	// relay -> backend-connecterでBackendIdがある場合はそのバックエンドの登録だけを解除する
+	ControlType_CONTROL_CLOSE ControlType = 3
+	// relay -> backend-connecter: 別のバックエンドにBackendIdのドメインを奪われたので登録を解除する
+	// 取り返し合わないように再接続しても登録しない. BackendIdがない場合はトンネルを閉じる
+	ControlType_CONTROL_DISPLACED ControlType = 4
+	// ConnectionIdのボディをWindow個受け取ったので続きを送ってよい
+	// relay -> backend-connecterはレスポンス、backend-connecter -> relayはリクエストのボディ
+	ControlType_CONTROL_WINDOW ControlType = 5
+)
+
+// Enum value maps for ControlType.
+var (
+	ControlType_name = map[int32]string{
+		0: "CONTROL_UNKNOWN",
+		1: "CONTROL_REGISTERED",
+		2: "CONTROL_CANCEL",
+		3: "CONTROL_CLOSE",
+		4: "CONTROL_DISPLACED",
+		5: "CONTROL_WINDOW",
+	}
+	ControlType_value = map[string]int32{
+		"CONTROL_UNKNOWN":    0,
+		"CONTROL_REGISTERED": 1,
+		"CONTROL_CANCEL":     2,
+		"CONTROL_CLOSE":      3,
+		"CONTROL_DISPLACED":  4,
+		"CONTROL_WINDOW":     5,
+	}
+)
+
+func (x ControlType) Enum() *ControlType {
+	p := new(ControlType)
+	*p = x
+	return p
+}
+
+func (x ControlType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ControlType) Descriptor() protoreflect.EnumDescriptor {
+	return file_remote_proto_enumTypes[1].Descriptor()
+}
+
+func (ControlType) Type() protoreflect.EnumType {
+	return &file_remote_proto_enumTypes[1]
+}
+
+func (x ControlType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ControlType.Descriptor instead.
+func (ControlType) EnumDescriptor() ([]byte, []int) {
+	return file_remote_proto_rawDescGZIP(), []int{1}
+}
+
 type Null struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -72,8 +191,15 @@ type Connection struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	DeveloperName string `protobuf:"bytes,1,opt,name=DeveloperName,proto3" json:"DeveloperName,omitempty"`
-	Domain        string `protobuf:"bytes,2,opt,name=Domain,proto3" json:"Domain,omitempty"`
+	DeveloperName string   `protobuf:"bytes,1,opt,name=DeveloperName,proto3" json:"DeveloperName,omitempty"`
+	Domain        string   `protobuf:"bytes,2,opt,name=Domain,proto3" json:"Domain,omitempty"`
+	Protocol      Protocol `protobuf:"varint,3,opt,name=Protocol,proto3,enum=Protocol" json:"Protocol,omitempty"`
+	// 同じドメインに複数のバックエンドが登録されているときに振り分ける割合
+	Weight int32 `protobuf:"varint,4,opt,name=Weight,proto3" json:"Weight,omitempty"`
+	// 1つのTunnelで複数のドメインを登録する. 空の場合はDomainとProtocolの1つだけを登録する
+	Domains []*DomainRegistration `protobuf:"bytes,5,rep,name=Domains,proto3" json:"Domains,omitempty"`
+	// Tunnelでtrueの場合、backend-connecterはリクエストのボディを読むたびにCONTROL_WINDOWを送る
+	FlowControl bool `protobuf:"varint,6,opt,name=FlowControl,proto3" json:"FlowControl,omitempty"`
 }
 
 func (x *Connection) Reset() {
@@ -122,21 +248,50 @@ func (x *Connection) GetDomain() string {
 	return ""
 }
 
-type HttpRequestWrapper struct {
+func (x *Connection) GetProtocol() Protocol {
+	if x != nil {
+		return x.Protocol
+	}
+	return Protocol_PROTOCOL_HTTP
+}
+
+func (x *Connection) GetWeight() int32 {
+	if x != nil {
+		return x.Weight
+	}
+	return 0
+}
+
+func (x *Connection) GetDomains() []*DomainRegistration {
+	if x != nil {
+		return x.Domains
+	}
+	return nil
+}
+
+func (x *Connection) GetFlowControl() bool {
+	if x != nil {
+		return x.FlowControl
+	}
+	return false
+}
+
+// DomainRegistration はbackend-connecterが登録するドメイン1つ分
+type DomainRegistration struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	HttpMethod     string                 `protobuf:"bytes,1,opt,name=HttpMethod,proto3" json:"HttpMethod,omitempty"`
-	Body           []byte                 `protobuf:"bytes,2,opt,name=Body,proto3" json:"Body,omitempty"`
-	Headers        map[string]*HttpHeader `protobuf:"bytes,3,rep,name=Headers,proto3" json:"Headers,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
-	HttpRequestURL string                 `protobuf:"bytes,4,opt,name=HttpRequestURL,proto3" json:"HttpRequestURL,omitempty"`
-	ConnectionId   string                 `protobuf:"bytes,5,opt,name=ConnectionId,proto3" json:"ConnectionId,omitempty"`
-	Domain         string                 `protobuf:"bytes,6,opt,name=Domain,proto3" json:"Domain,omitempty"`
+	Domain   string   `protobuf:"bytes,1,opt,name=Domain,proto3" json:"Domain,omitempty"`
+	Protocol Protocol `protobuf:"varint,2,opt,name=Protocol,proto3,enum=Protocol" json:"Protocol,omitempty"`
+	// relayが払い出したバックエンドのID. CONTROL_REGISTEREDで返す
+	BackendId string `protobuf:"bytes,3,opt,name=BackendId,proto3" json:"BackendId,omitempty"`
+	// CONTROL_REGISTEREDで登録できなかった理由. 登録できた場合は空
+	Error string `protobuf:"bytes,4,opt,name=Error,proto3" json:"Error,omitempty"`
 }
 
-func (x *HttpRequestWrapper) Reset() {
-	*x = HttpRequestWrapper{}
+func (x *DomainRegistration) Reset() {
+	*x = DomainRegistration{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_remote_proto_msgTypes[2]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -144,13 +299,13 @@ func (x *HttpRequestWrapper) Reset() {
 	}
 }
 
-func (x *HttpRequestWrapper) String() string {
+func (x *DomainRegistration) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*HttpRequestWrapper) ProtoMessage() {}
+func (*DomainRegistration) ProtoMessage() {}
 
-func (x *HttpRequestWrapper) ProtoReflect() protoreflect.Message {
+func (x *DomainRegistration) ProtoReflect() protoreflect.Message {
 	mi := &file_remote_proto_msgTypes[2]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -162,64 +317,49 @@ func (x *HttpRequestWrapper) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use HttpRequestWrapper.ProtoReflect.Descriptor instead.
-func (*HttpRequestWrapper) Descriptor() ([]byte, []int) {
+// Deprecated: Use DomainRegistration.ProtoReflect.Descriptor instead.
+func (*DomainRegistration) Descriptor() ([]byte, []int) {
 	return file_remote_proto_rawDescGZIP(), []int{2}
 }
 
-func (x *HttpRequestWrapper) GetHttpMethod() string {
+func (x *DomainRegistration) GetDomain() string {
 	if x != nil {
-		return x.HttpMethod
+		return x.Domain
 	}
 	return ""
 }
 
-func (x *HttpRequestWrapper) GetBody() []byte {
-	if x != nil {
-		return x.Body
-	}
-	return nil
-}
-
-func (x *HttpRequestWrapper) GetHeaders() map[string]*HttpHeader {
-	if x != nil {
-		return x.Headers
-	}
-	return nil
-}
-
-func (x *HttpRequestWrapper) GetHttpRequestURL() string {
+func (x *DomainRegistration) GetProtocol() Protocol {
 	if x != nil {
-		return x.HttpRequestURL
+		return x.Protocol
 	}
-	return ""
+	return Protocol_PROTOCOL_HTTP
 }
 
-func (x *HttpRequestWrapper) GetConnectionId() string {
+func (x *DomainRegistration) GetBackendId() string {
 	if x != nil {
-		return x.ConnectionId
+		return x.BackendId
 	}
 	return ""
 }
 
-func (x *HttpRequestWrapper) GetDomain() string {
+func (x *DomainRegistration) GetError() string {
 	if x != nil {
-		return x.Domain
+		return x.Error
 	}
 	return ""
 }
 
-type HttpHeader struct {
+type DomainList struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Key   string   `protobuf:"bytes,1,opt,name=Key,proto3" json:"Key,omitempty"`
-	Value []string `protobuf:"bytes,2,rep,name=Value,proto3" json:"Value,omitempty"`
+	Domains []*Connection `protobuf:"bytes,1,rep,name=Domains,proto3" json:"Domains,omitempty"`
 }
 
-func (x *HttpHeader) Reset() {
-	*x = HttpHeader{}
+func (x *DomainList) Reset() {
+	*x = DomainList{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_remote_proto_msgTypes[3]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -227,13 +367,13 @@ func (x *HttpHeader) Reset() {
 	}
 }
 
-func (x *HttpHeader) String() string {
+func (x *DomainList) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*HttpHeader) ProtoMessage() {}
+func (*DomainList) ProtoMessage() {}
 
-func (x *HttpHeader) ProtoReflect() protoreflect.Message {
+func (x *DomainList) ProtoReflect() protoreflect.Message {
 	mi := &file_remote_proto_msgTypes[3]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -245,38 +385,40 @@ func (x *HttpHeader) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use HttpHeader.ProtoReflect.Descriptor instead.
-func (*HttpHeader) Descriptor() ([]byte, []int) {
+// Deprecated: Use DomainList.ProtoReflect.Descriptor instead.
+func (*DomainList) Descriptor() ([]byte, []int) {
 	return file_remote_proto_rawDescGZIP(), []int{3}
 }
 
-func (x *HttpHeader) GetKey() string {
-	if x != nil {
-		return x.Key
-	}
-	return ""
-}
-
-func (x *HttpHeader) GetValue() []string {
+func (x *DomainList) GetDomains() []*Connection {
 	if x != nil {
-		return x.Value
+		return x.Domains
 	}
 	return nil
 }
 
-type HttpResponseWrapper struct {
+type BackendInfo struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Body         []byte                 `protobuf:"bytes,1,opt,name=Body,proto3" json:"Body,omitempty"`
-	Headers      map[string]*HttpHeader `protobuf:"bytes,2,rep,name=Headers,proto3" json:"Headers,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
-	Status       int32                  `protobuf:"varint,3,opt,name=Status,proto3" json:"Status,omitempty"`
-	ConnectionId string                 `protobuf:"bytes,4,opt,name=ConnectionId,proto3" json:"ConnectionId,omitempty"`
-}
-
-func (x *HttpResponseWrapper) Reset() {
-	*x = HttpResponseWrapper{}
+	BackendId     string   `protobuf:"bytes,1,opt,name=BackendId,proto3" json:"BackendId,omitempty"`
+	Domain        string   `protobuf:"bytes,2,opt,name=Domain,proto3" json:"Domain,omitempty"`
+	DeveloperName string   `protobuf:"bytes,3,opt,name=DeveloperName,proto3" json:"DeveloperName,omitempty"`
+	Protocol      Protocol `protobuf:"varint,4,opt,name=Protocol,proto3,enum=Protocol" json:"Protocol,omitempty"`
+	// unix time(ナノ秒)
+	ConnectedAt int64 `protobuf:"varint,5,opt,name=ConnectedAt,proto3" json:"ConnectedAt,omitempty"`
+	// backend-connecterのアドレス
+	RemoteAddr string `protobuf:"bytes,6,opt,name=RemoteAddr,proto3" json:"RemoteAddr,omitempty"`
+	// 処理中のリクエストの数
+	InFlight int64 `protobuf:"varint,7,opt,name=InFlight,proto3" json:"InFlight,omitempty"`
+	// 新しいリクエストを受け付けていない
+	Drained bool  `protobuf:"varint,8,opt,name=Drained,proto3" json:"Drained,omitempty"`
+	Weight  int32 `protobuf:"varint,9,opt,name=Weight,proto3" json:"Weight,omitempty"`
+}
+
+func (x *BackendInfo) Reset() {
+	*x = BackendInfo{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_remote_proto_msgTypes[4]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -284,13 +426,13 @@ func (x *HttpResponseWrapper) Reset() {
 	}
 }
 
-func (x *HttpResponseWrapper) String() string {
+func (x *BackendInfo) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*HttpResponseWrapper) ProtoMessage() {}
+func (*BackendInfo) ProtoMessage() {}
 
-func (x *HttpResponseWrapper) ProtoReflect() protoreflect.Message {
+func (x *BackendInfo) ProtoReflect() protoreflect.Message {
 	mi := &file_remote_proto_msgTypes[4]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -302,148 +444,1248 @@ func (x *HttpResponseWrapper) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use HttpResponseWrapper.ProtoReflect.Descriptor instead.
-func (*HttpResponseWrapper) Descriptor() ([]byte, []int) {
+// Deprecated: Use BackendInfo.ProtoReflect.Descriptor instead.
+func (*BackendInfo) Descriptor() ([]byte, []int) {
 	return file_remote_proto_rawDescGZIP(), []int{4}
 }
 
-func (x *HttpResponseWrapper) GetBody() []byte {
+func (x *BackendInfo) GetBackendId() string {
 	if x != nil {
-		return x.Body
+		return x.BackendId
 	}
-	return nil
+	return ""
 }
 
-func (x *HttpResponseWrapper) GetHeaders() map[string]*HttpHeader {
+func (x *BackendInfo) GetDomain() string {
 	if x != nil {
-		return x.Headers
+		return x.Domain
 	}
-	return nil
+	return ""
 }
 
-func (x *HttpResponseWrapper) GetStatus() int32 {
+func (x *BackendInfo) GetDeveloperName() string {
 	if x != nil {
-		return x.Status
+		return x.DeveloperName
+	}
+	return ""
+}
+
+func (x *BackendInfo) GetProtocol() Protocol {
+	if x != nil {
+		return x.Protocol
+	}
+	return Protocol_PROTOCOL_HTTP
+}
+
+func (x *BackendInfo) GetConnectedAt() int64 {
+	if x != nil {
+		return x.ConnectedAt
 	}
 	return 0
 }
 
-func (x *HttpResponseWrapper) GetConnectionId() string {
+func (x *BackendInfo) GetRemoteAddr() string {
 	if x != nil {
-		return x.ConnectionId
+		return x.RemoteAddr
 	}
 	return ""
 }
 
-var File_remote_proto protoreflect.FileDescriptor
+func (x *BackendInfo) GetInFlight() int64 {
+	if x != nil {
+		return x.InFlight
+	}
+	return 0
+}
 
-var file_remote_proto_rawDesc = []byte{
-	0x0a, 0x0c, 0x72, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x06,
-	0x0a, 0x04, 0x4e, 0x75, 0x6c, 0x6c, 0x22, 0x4a, 0x0a, 0x0a, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63,
-	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x24, 0x0a, 0x0d, 0x44, 0x65, 0x76, 0x65, 0x6c, 0x6f, 0x70, 0x65,
-	0x72, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x44, 0x65, 0x76,
-	0x65, 0x6c, 0x6f, 0x70, 0x65, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x44, 0x6f,
-	0x6d, 0x61, 0x69, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x44, 0x6f, 0x6d, 0x61,
-	0x69, 0x6e, 0x22, 0xb1, 0x02, 0x0a, 0x12, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65,
-	0x73, 0x74, 0x57, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x12, 0x1e, 0x0a, 0x0a, 0x48, 0x74, 0x74,
-	0x70, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x48,
-	0x74, 0x74, 0x70, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x42, 0x6f, 0x64,
-	0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x42, 0x6f, 0x64, 0x79, 0x12, 0x3a, 0x0a,
-	0x07, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x20,
-	0x2e, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x57, 0x72, 0x61, 0x70,
-	0x70, 0x65, 0x72, 0x2e, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79,
-	0x52, 0x07, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x12, 0x26, 0x0a, 0x0e, 0x48, 0x74, 0x74,
-	0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x55, 0x52, 0x4c, 0x18, 0x04, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x0e, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x55, 0x52,
-	0x4c, 0x12, 0x22, 0x0a, 0x0c, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49,
-	0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74,
-	0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x18,
-	0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x1a, 0x47, 0x0a,
-	0x0c, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a,
-	0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12,
-	0x21, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b,
-	0x2e, 0x48, 0x74, 0x74, 0x70, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x52, 0x05, 0x76, 0x61, 0x6c,
-	0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x34, 0x0a, 0x0a, 0x48, 0x74, 0x74, 0x70, 0x48, 0x65,
-	0x61, 0x64, 0x65, 0x72, 0x12, 0x10, 0x0a, 0x03, 0x4b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x03, 0x4b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x18,
-	0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x05, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x22, 0xeb, 0x01, 0x0a,
-	0x13, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x57, 0x72, 0x61,
-	0x70, 0x70, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x0c, 0x52, 0x04, 0x42, 0x6f, 0x64, 0x79, 0x12, 0x3b, 0x0a, 0x07, 0x48, 0x65, 0x61, 0x64,
-	0x65, 0x72, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x21, 0x2e, 0x48, 0x74, 0x74, 0x70,
-	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x57, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x2e,
-	0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x48, 0x65,
-	0x61, 0x64, 0x65, 0x72, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18,
-	0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x22, 0x0a,
-	0x0c, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x18, 0x04, 0x20,
-	0x01, 0x28, 0x09, 0x52, 0x0c, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49,
-	0x64, 0x1a, 0x47, 0x0a, 0x0c, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72,
-	0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03,
-	0x6b, 0x65, 0x79, 0x12, 0x21, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01,
-	0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x48, 0x74, 0x74, 0x70, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x52,
-	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x32, 0xb0, 0x01, 0x0a, 0x05, 0x50,
-	0x72, 0x6f, 0x78, 0x79, 0x12, 0x3f, 0x0a, 0x10, 0x46, 0x72, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x64,
-	0x45, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x12, 0x13, 0x2e, 0x48, 0x74, 0x74, 0x70, 0x52,
-	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x57, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x1a, 0x14, 0x2e,
-	0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x57, 0x72, 0x61, 0x70,
-	0x70, 0x65, 0x72, 0x22, 0x00, 0x12, 0x36, 0x0a, 0x0e, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64,
-	0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x12, 0x0b, 0x2e, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63,
-	0x74, 0x69, 0x6f, 0x6e, 0x1a, 0x13, 0x2e, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65,
-	0x73, 0x74, 0x57, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x22, 0x00, 0x30, 0x01, 0x12, 0x2e, 0x0a,
-	0x0b, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x53, 0x65, 0x6e, 0x64, 0x12, 0x14, 0x2e, 0x48,
-	0x74, 0x74, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x57, 0x72, 0x61, 0x70, 0x70,
-	0x65, 0x72, 0x1a, 0x05, 0x2e, 0x4e, 0x75, 0x6c, 0x6c, 0x22, 0x00, 0x28, 0x01, 0x62, 0x06, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x33,
+func (x *BackendInfo) GetDrained() bool {
+	if x != nil {
+		return x.Drained
+	}
+	return false
 }
 
-var (
-	file_remote_proto_rawDescOnce sync.Once
-	file_remote_proto_rawDescData = file_remote_proto_rawDesc
-)
+func (x *BackendInfo) GetWeight() int32 {
+	if x != nil {
+		return x.Weight
+	}
+	return 0
+}
 
-func file_remote_proto_rawDescGZIP() []byte {
-	file_remote_proto_rawDescOnce.Do(func() {
-		file_remote_proto_rawDescData = protoimpl.X.CompressGZIP(file_remote_proto_rawDescData)
-	})
-	return file_remote_proto_rawDescData
+type BackendList struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Backends []*BackendInfo `protobuf:"bytes,1,rep,name=Backends,proto3" json:"Backends,omitempty"`
 }
 
-var file_remote_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
-var file_remote_proto_goTypes = []interface{}{
-	(*Null)(nil),                // 0: Null
-	(*Connection)(nil),          // 1: Connection
-	(*HttpRequestWrapper)(nil),  // 2: HttpRequestWrapper
-	(*HttpHeader)(nil),          // 3: HttpHeader
-	(*HttpResponseWrapper)(nil), // 4: HttpResponseWrapper
-	nil,                         // 5: HttpRequestWrapper.HeadersEntry
-	nil,                         // 6: HttpResponseWrapper.HeadersEntry
+func (x *BackendList) Reset() {
+	*x = BackendList{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_remote_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
 }
-var file_remote_proto_depIdxs = []int32{
-	5, // 0: HttpRequestWrapper.Headers:type_name -> HttpRequestWrapper.HeadersEntry
-	6, // 1: HttpResponseWrapper.Headers:type_name -> HttpResponseWrapper.HeadersEntry
-	3, // 2: HttpRequestWrapper.HeadersEntry.value:type_name -> HttpHeader
-	3, // 3: HttpResponseWrapper.HeadersEntry.value:type_name -> HttpHeader
-	2, // 4: Proxy.FrontendEndpoint:input_type -> HttpRequestWrapper
-	1, // 5: Proxy.BackendReceive:input_type -> Connection
-	4, // 6: Proxy.BackendSend:input_type -> HttpResponseWrapper
-	4, // 7: Proxy.FrontendEndpoint:output_type -> HttpResponseWrapper
-	2, // 8: Proxy.BackendReceive:output_type -> HttpRequestWrapper
-	0, // 9: Proxy.BackendSend:output_type -> Null
-	7, // [7:10] is the sub-list for method output_type
-	4, // [4:7] is the sub-list for method input_type
-	4, // [4:4] is the sub-list for extension type_name
-	4, // [4:4] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+
+func (x *BackendList) String() string {
+	return protoimpl.X.MessageStringOf(x)
 }
 
-func init() { file_remote_proto_init() }
-func file_remote_proto_init() {
-	if File_remote_proto != nil {
-		return
+func (*BackendList) ProtoMessage() {}
+
+func (x *BackendList) ProtoReflect() protoreflect.Message {
+	mi := &file_remote_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
 	}
-	if !protoimpl.UnsafeEnabled {
-		file_remote_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Null); i {
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BackendList.ProtoReflect.Descriptor instead.
+func (*BackendList) Descriptor() ([]byte, []int) {
+	return file_remote_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *BackendList) GetBackends() []*BackendInfo {
+	if x != nil {
+		return x.Backends
+	}
+	return nil
+}
+
+type HttpRequestWrapper struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	HttpMethod     string                 `protobuf:"bytes,1,opt,name=HttpMethod,proto3" json:"HttpMethod,omitempty"`
+	Body           []byte                 `protobuf:"bytes,2,opt,name=Body,proto3" json:"Body,omitempty"`
+	Headers        map[string]*HttpHeader `protobuf:"bytes,3,rep,name=Headers,proto3" json:"Headers,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	HttpRequestURL string                 `protobuf:"bytes,4,opt,name=HttpRequestURL,proto3" json:"HttpRequestURL,omitempty"`
+	ConnectionId   string                 `protobuf:"bytes,5,opt,name=ConnectionId,proto3" json:"ConnectionId,omitempty"`
+	Domain         string                 `protobuf:"bytes,6,opt,name=Domain,proto3" json:"Domain,omitempty"`
+	// unix time(ナノ秒). 0の場合は期限なし
+	Deadline int64 `protobuf:"varint,7,opt,name=Deadline,proto3" json:"Deadline,omitempty"`
+	// trueの場合ボディはBodyChunkで後から送る
+	StreamBody bool `protobuf:"varint,8,opt,name=StreamBody,proto3" json:"StreamBody,omitempty"`
+	// websocketなどプロトコルを切り替えるリクエスト
+	// 切り替えた後は両方向のBodyChunkでそのままバイト列を流す
+	Upgrade bool `protobuf:"varint,9,opt,name=Upgrade,proto3" json:"Upgrade,omitempty"`
+	// PROTOCOL_TCPとPROTOCOL_UDPの場合はHTTPのフィールドを使わず、Responseを返した後に両方向のBodyChunkでバイト列を流す
+	Protocol Protocol `protobuf:"varint,10,opt,name=Protocol,proto3,enum=Protocol" json:"Protocol,omitempty"`
+	// relayがリクエストを渡したバックエンドのID. 複数のドメインを登録したbackend-connecterが送り先を選ぶのに使う
+	BackendId string `protobuf:"bytes,11,opt,name=BackendId,proto3" json:"BackendId,omitempty"`
+}
+
+func (x *HttpRequestWrapper) Reset() {
+	*x = HttpRequestWrapper{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_remote_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HttpRequestWrapper) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HttpRequestWrapper) ProtoMessage() {}
+
+func (x *HttpRequestWrapper) ProtoReflect() protoreflect.Message {
+	mi := &file_remote_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HttpRequestWrapper.ProtoReflect.Descriptor instead.
+func (*HttpRequestWrapper) Descriptor() ([]byte, []int) {
+	return file_remote_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *HttpRequestWrapper) GetHttpMethod() string {
+	if x != nil {
+		return x.HttpMethod
+	}
+	return ""
+}
+
+func (x *HttpRequestWrapper) GetBody() []byte {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+func (x *HttpRequestWrapper) GetHeaders() map[string]*HttpHeader {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *HttpRequestWrapper) GetHttpRequestURL() string {
+	if x != nil {
+		return x.HttpRequestURL
+	}
+	return ""
+}
+
+func (x *HttpRequestWrapper) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *HttpRequestWrapper) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *HttpRequestWrapper) GetDeadline() int64 {
+	if x != nil {
+		return x.Deadline
+	}
+	return 0
+}
+
+func (x *HttpRequestWrapper) GetStreamBody() bool {
+	if x != nil {
+		return x.StreamBody
+	}
+	return false
+}
+
+func (x *HttpRequestWrapper) GetUpgrade() bool {
+	if x != nil {
+		return x.Upgrade
+	}
+	return false
+}
+
+func (x *HttpRequestWrapper) GetProtocol() Protocol {
+	if x != nil {
+		return x.Protocol
+	}
+	return Protocol_PROTOCOL_HTTP
+}
+
+func (x *HttpRequestWrapper) GetBackendId() string {
+	if x != nil {
+		return x.BackendId
+	}
+	return ""
+}
+
+type HttpHeader struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key   string   `protobuf:"bytes,1,opt,name=Key,proto3" json:"Key,omitempty"`
+	Value []string `protobuf:"bytes,2,rep,name=Value,proto3" json:"Value,omitempty"`
+}
+
+func (x *HttpHeader) Reset() {
+	*x = HttpHeader{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_remote_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HttpHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HttpHeader) ProtoMessage() {}
+
+func (x *HttpHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_remote_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HttpHeader.ProtoReflect.Descriptor instead.
+func (*HttpHeader) Descriptor() ([]byte, []int) {
+	return file_remote_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *HttpHeader) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *HttpHeader) GetValue() []string {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+type HttpResponseWrapper struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Body         []byte                 `protobuf:"bytes,1,opt,name=Body,proto3" json:"Body,omitempty"`
+	Headers      map[string]*HttpHeader `protobuf:"bytes,2,rep,name=Headers,proto3" json:"Headers,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Status       int32                  `protobuf:"varint,3,opt,name=Status,proto3" json:"Status,omitempty"`
+	ConnectionId string                 `protobuf:"bytes,4,opt,name=ConnectionId,proto3" json:"ConnectionId,omitempty"`
+	// trueの場合ボディはBodyChunkで後から送る
+	StreamBody bool `protobuf:"varint,5,opt,name=StreamBody,proto3" json:"StreamBody,omitempty"`
+}
+
+func (x *HttpResponseWrapper) Reset() {
+	*x = HttpResponseWrapper{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_remote_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HttpResponseWrapper) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HttpResponseWrapper) ProtoMessage() {}
+
+func (x *HttpResponseWrapper) ProtoReflect() protoreflect.Message {
+	mi := &file_remote_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HttpResponseWrapper.ProtoReflect.Descriptor instead.
+func (*HttpResponseWrapper) Descriptor() ([]byte, []int) {
+	return file_remote_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *HttpResponseWrapper) GetBody() []byte {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+func (x *HttpResponseWrapper) GetHeaders() map[string]*HttpHeader {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *HttpResponseWrapper) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *HttpResponseWrapper) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *HttpResponseWrapper) GetStreamBody() bool {
+	if x != nil {
+		return x.StreamBody
+	}
+	return false
+}
+
+type BodyChunk struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ConnectionId string `protobuf:"bytes,1,opt,name=ConnectionId,proto3" json:"ConnectionId,omitempty"`
+	Data         []byte `protobuf:"bytes,2,opt,name=Data,proto3" json:"Data,omitempty"`
+	// ボディの終わり
+	Eof bool `protobuf:"varint,3,opt,name=Eof,proto3" json:"Eof,omitempty"`
+	// ボディの途中で失敗した場合に理由を入れる
+	Error string `protobuf:"bytes,4,opt,name=Error,proto3" json:"Error,omitempty"`
+	// PROTOCOL_UDPの場合にclientとrelayの間でデータグラムの送信元を区別するアドレス
+	SourceAddr string `protobuf:"bytes,5,opt,name=SourceAddr,proto3" json:"SourceAddr,omitempty"`
+}
+
+func (x *BodyChunk) Reset() {
+	*x = BodyChunk{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_remote_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BodyChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BodyChunk) ProtoMessage() {}
+
+func (x *BodyChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_remote_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BodyChunk.ProtoReflect.Descriptor instead.
+func (*BodyChunk) Descriptor() ([]byte, []int) {
+	return file_remote_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *BodyChunk) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *BodyChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *BodyChunk) GetEof() bool {
+	if x != nil {
+		return x.Eof
+	}
+	return false
+}
+
+func (x *BodyChunk) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *BodyChunk) GetSourceAddr() string {
+	if x != nil {
+		return x.SourceAddr
+	}
+	return ""
+}
+
+type FrontendFrame struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Frame:
+	//	*FrontendFrame_Request
+	//	*FrontendFrame_Response
+	//	*FrontendFrame_Body
+	Frame isFrontendFrame_Frame `protobuf_oneof:"Frame"`
+}
+
+func (x *FrontendFrame) Reset() {
+	*x = FrontendFrame{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_remote_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FrontendFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FrontendFrame) ProtoMessage() {}
+
+func (x *FrontendFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_remote_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FrontendFrame.ProtoReflect.Descriptor instead.
+func (*FrontendFrame) Descriptor() ([]byte, []int) {
+	return file_remote_proto_rawDescGZIP(), []int{10}
+}
+
+func (m *FrontendFrame) GetFrame() isFrontendFrame_Frame {
+	if m != nil {
+		return m.Frame
+	}
+	return nil
+}
+
+func (x *FrontendFrame) GetRequest() *HttpRequestWrapper {
+	if x, ok := x.GetFrame().(*FrontendFrame_Request); ok {
+		return x.Request
+	}
+	return nil
+}
+
+func (x *FrontendFrame) GetResponse() *HttpResponseWrapper {
+	if x, ok := x.GetFrame().(*FrontendFrame_Response); ok {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *FrontendFrame) GetBody() *BodyChunk {
+	if x, ok := x.GetFrame().(*FrontendFrame_Body); ok {
+		return x.Body
+	}
+	return nil
+}
+
+type isFrontendFrame_Frame interface {
+	isFrontendFrame_Frame()
+}
+
+type FrontendFrame_Request struct {
+	Request *HttpRequestWrapper `protobuf:"bytes,1,opt,name=Request,proto3,oneof"`
+}
+
+type FrontendFrame_Response struct {
+	Response *HttpResponseWrapper `protobuf:"bytes,2,opt,name=Response,proto3,oneof"`
+}
+
+type FrontendFrame_Body struct {
+	Body *BodyChunk `protobuf:"bytes,3,opt,name=Body,proto3,oneof"`
+}
+
+func (*FrontendFrame_Request) isFrontendFrame_Frame() {}
+
+func (*FrontendFrame_Response) isFrontendFrame_Frame() {}
+
+func (*FrontendFrame_Body) isFrontendFrame_Frame() {}
+
+type TunnelFrame struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Frame:
+	//	*TunnelFrame_Register
+	//	*TunnelFrame_Request
+	//	*TunnelFrame_Response
+	//	*TunnelFrame_Heartbeat
+	//	*TunnelFrame_Control
+	//	*TunnelFrame_Body
+	Frame isTunnelFrame_Frame `protobuf_oneof:"Frame"`
+}
+
+func (x *TunnelFrame) Reset() {
+	*x = TunnelFrame{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_remote_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TunnelFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TunnelFrame) ProtoMessage() {}
+
+func (x *TunnelFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_remote_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TunnelFrame.ProtoReflect.Descriptor instead.
+func (*TunnelFrame) Descriptor() ([]byte, []int) {
+	return file_remote_proto_rawDescGZIP(), []int{11}
+}
+
+func (m *TunnelFrame) GetFrame() isTunnelFrame_Frame {
+	if m != nil {
+		return m.Frame
+	}
+	return nil
+}
+
+func (x *TunnelFrame) GetRegister() *Connection {
+	if x, ok := x.GetFrame().(*TunnelFrame_Register); ok {
+		return x.Register
+	}
+	return nil
+}
+
+func (x *TunnelFrame) GetRequest() *HttpRequestWrapper {
+	if x, ok := x.GetFrame().(*TunnelFrame_Request); ok {
+		return x.Request
+	}
+	return nil
+}
+
+func (x *TunnelFrame) GetResponse() *HttpResponseWrapper {
+	if x, ok := x.GetFrame().(*TunnelFrame_Response); ok {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *TunnelFrame) GetHeartbeat() *Heartbeat {
+	if x, ok := x.GetFrame().(*TunnelFrame_Heartbeat); ok {
+		return x.Heartbeat
+	}
+	return nil
+}
+
+func (x *TunnelFrame) GetControl() *Control {
+	if x, ok := x.GetFrame().(*TunnelFrame_Control); ok {
+		return x.Control
+	}
+	return nil
+}
+
+func (x *TunnelFrame) GetBody() *BodyChunk {
+	if x, ok := x.GetFrame().(*TunnelFrame_Body); ok {
+		return x.Body
+	}
+	return nil
+}
+
+type isTunnelFrame_Frame interface {
+	isTunnelFrame_Frame()
+}
+
+type TunnelFrame_Register struct {
+	// backend-connecter -> relay
+	Register *Connection `protobuf:"bytes,1,opt,name=Register,proto3,oneof"`
+}
+
+type TunnelFrame_Request struct {
+	// relay -> backend-connecter
+	Request *HttpRequestWrapper `protobuf:"bytes,2,opt,name=Request,proto3,oneof"`
+}
+
+type TunnelFrame_Response struct {
+	// backend-connecter -> relay
+	Response *HttpResponseWrapper `protobuf:"bytes,3,opt,name=Response,proto3,oneof"`
+}
+
+type TunnelFrame_Heartbeat struct {
+	Heartbeat *Heartbeat `protobuf:"bytes,4,opt,name=Heartbeat,proto3,oneof"`
+}
+
+type TunnelFrame_Control struct {
+	Control *Control `protobuf:"bytes,5,opt,name=Control,proto3,oneof"`
+}
+
+type TunnelFrame_Body struct {
+	// 両方向
+	Body *BodyChunk `protobuf:"bytes,6,opt,name=Body,proto3,oneof"`
+}
+
+func (*TunnelFrame_Register) isTunnelFrame_Frame() {}
+
+func (*TunnelFrame_Request) isTunnelFrame_Frame() {}
+
+func (*TunnelFrame_Response) isTunnelFrame_Frame() {}
+
+func (*TunnelFrame_Heartbeat) isTunnelFrame_Frame() {}
+
+func (*TunnelFrame_Control) isTunnelFrame_Frame() {}
+
+func (*TunnelFrame_Body) isTunnelFrame_Frame() {}
+
+type Heartbeat struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// unix time(ナノ秒)
+	Timestamp int64 `protobuf:"varint,1,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
+}
+
+func (x *Heartbeat) Reset() {
+	*x = Heartbeat{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_remote_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Heartbeat) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Heartbeat) ProtoMessage() {}
+
+func (x *Heartbeat) ProtoReflect() protoreflect.Message {
+	mi := &file_remote_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Heartbeat.ProtoReflect.Descriptor instead.
+func (*Heartbeat) Descriptor() ([]byte, []int) {
+	return file_remote_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *Heartbeat) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+type Control struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type         ControlType `protobuf:"varint,1,opt,name=Type,proto3,enum=ControlType" json:"Type,omitempty"`
+	BackendId    string      `protobuf:"bytes,2,opt,name=BackendId,proto3" json:"BackendId,omitempty"`
+	ConnectionId string      `protobuf:"bytes,3,opt,name=ConnectionId,proto3" json:"ConnectionId,omitempty"`
+	Message      string      `protobuf:"bytes,4,opt,name=Message,proto3" json:"Message,omitempty"`
+	// CONTROL_REGISTEREDでrelayが割り当てたドメイン
+	Domain string `protobuf:"bytes,5,opt,name=Domain,proto3" json:"Domain,omitempty"`
+	// CONTROL_REGISTEREDで登録したドメインをConnection.Domainsと同じ順に返す
+	Registrations []*DomainRegistration `protobuf:"bytes,6,rep,name=Registrations,proto3" json:"Registrations,omitempty"`
+	// CONTROL_REGISTEREDでtrueの場合、backend-connecterはCONTROL_WINDOWを待ちながらレスポンスのボディを送る
+	FlowControl bool `protobuf:"varint,7,opt,name=FlowControl,proto3" json:"FlowControl,omitempty"`
+	// CONTROL_WINDOWで受け取ったBodyChunkの数
+	Window int32 `protobuf:"varint,8,opt,name=Window,proto3" json:"Window,omitempty"`
+	// CONTROL_REGISTEREDでtrueの場合、relayはCONTROL_WINDOWを待ちながらリクエストのボディを送る
+	RequestFlowControl bool `protobuf:"varint,9,opt,name=RequestFlowControl,proto3" json:"RequestFlowControl,omitempty"`
+}
+
+func (x *Control) Reset() {
+	*x = Control{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_remote_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Control) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Control) ProtoMessage() {}
+
+func (x *Control) ProtoReflect() protoreflect.Message {
+	mi := &file_remote_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Control.ProtoReflect.Descriptor instead.
+func (*Control) Descriptor() ([]byte, []int) {
+	return file_remote_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *Control) GetType() ControlType {
+	if x != nil {
+		return x.Type
+	}
+	return ControlType_CONTROL_UNKNOWN
+}
+
+func (x *Control) GetBackendId() string {
+	if x != nil {
+		return x.BackendId
+	}
+	return ""
+}
+
+func (x *Control) GetConnectionId() string {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return ""
+}
+
+func (x *Control) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Control) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *Control) GetRegistrations() []*DomainRegistration {
+	if x != nil {
+		return x.Registrations
+	}
+	return nil
+}
+
+func (x *Control) GetFlowControl() bool {
+	if x != nil {
+		return x.FlowControl
+	}
+	return false
+}
+
+func (x *Control) GetWindow() int32 {
+	if x != nil {
+		return x.Window
+	}
+	return 0
+}
+
+func (x *Control) GetRequestFlowControl() bool {
+	if x != nil {
+		return x.RequestFlowControl
+	}
+	return false
+}
+
+var File_remote_proto protoreflect.FileDescriptor
+
+var file_remote_proto_rawDesc = []byte{
+	0x0a, 0x0c, 0x72, 0x65, 0x6d, 0x6f, 0x74, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x06,
+	0x0a, 0x04, 0x4e, 0x75, 0x6c, 0x6c, 0x22, 0xda, 0x01, 0x0a, 0x0a, 0x43, 0x6f, 0x6e, 0x6e, 0x65,
+	0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x24, 0x0a, 0x0d, 0x44, 0x65, 0x76, 0x65, 0x6c, 0x6f, 0x70,
+	0x65, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x44, 0x65,
+	0x76, 0x65, 0x6c, 0x6f, 0x70, 0x65, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x44,
+	0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x44, 0x6f, 0x6d,
+	0x61, 0x69, 0x6e, 0x12, 0x25, 0x0a, 0x08, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x09, 0x2e, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c,
+	0x52, 0x08, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x12, 0x16, 0x0a, 0x06, 0x57, 0x65,
+	0x69, 0x67, 0x68, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x57, 0x65, 0x69, 0x67,
+	0x68, 0x74, 0x12, 0x2d, 0x0a, 0x07, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x73, 0x18, 0x05, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x52, 0x65, 0x67, 0x69,
+	0x73, 0x74, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x07, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e,
+	0x73, 0x12, 0x20, 0x0a, 0x0b, 0x46, 0x6c, 0x6f, 0x77, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c,
+	0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0b, 0x46, 0x6c, 0x6f, 0x77, 0x43, 0x6f, 0x6e, 0x74,
+	0x72, 0x6f, 0x6c, 0x22, 0x87, 0x01, 0x0a, 0x12, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x52, 0x65,
+	0x67, 0x69, 0x73, 0x74, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x44, 0x6f,
+	0x6d, 0x61, 0x69, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x44, 0x6f, 0x6d, 0x61,
+	0x69, 0x6e, 0x12, 0x25, 0x0a, 0x08, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x09, 0x2e, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x52,
+	0x08, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x12, 0x1c, 0x0a, 0x09, 0x42, 0x61, 0x63,
+	0x6b, 0x65, 0x6e, 0x64, 0x49, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x42, 0x61,
+	0x63, 0x6b, 0x65, 0x6e, 0x64, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x45, 0x72, 0x72, 0x6f, 0x72,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x22, 0x33, 0x0a,
+	0x0a, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x25, 0x0a, 0x07, 0x44,
+	0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x43,
+	0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x07, 0x44, 0x6f, 0x6d, 0x61, 0x69,
+	0x6e, 0x73, 0x22, 0xa0, 0x02, 0x0a, 0x0b, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x49, 0x6e,
+	0x66, 0x6f, 0x12, 0x1c, 0x0a, 0x09, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x49, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x49, 0x64,
+	0x12, 0x16, 0x0a, 0x06, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x12, 0x24, 0x0a, 0x0d, 0x44, 0x65, 0x76, 0x65,
+	0x6c, 0x6f, 0x70, 0x65, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0d, 0x44, 0x65, 0x76, 0x65, 0x6c, 0x6f, 0x70, 0x65, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x25,
+	0x0a, 0x08, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0e,
+	0x32, 0x09, 0x2e, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x52, 0x08, 0x50, 0x72, 0x6f,
+	0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x12, 0x20, 0x0a, 0x0b, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74,
+	0x65, 0x64, 0x41, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x43, 0x6f, 0x6e, 0x6e,
+	0x65, 0x63, 0x74, 0x65, 0x64, 0x41, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x52, 0x65, 0x6d, 0x6f, 0x74,
+	0x65, 0x41, 0x64, 0x64, 0x72, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x52, 0x65, 0x6d,
+	0x6f, 0x74, 0x65, 0x41, 0x64, 0x64, 0x72, 0x12, 0x1a, 0x0a, 0x08, 0x49, 0x6e, 0x46, 0x6c, 0x69,
+	0x67, 0x68, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x49, 0x6e, 0x46, 0x6c, 0x69,
+	0x67, 0x68, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x44, 0x72, 0x61, 0x69, 0x6e, 0x65, 0x64, 0x18, 0x08,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x44, 0x72, 0x61, 0x69, 0x6e, 0x65, 0x64, 0x12, 0x16, 0x0a,
+	0x06, 0x57, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x09, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x57,
+	0x65, 0x69, 0x67, 0x68, 0x74, 0x22, 0x37, 0x0a, 0x0b, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64,
+	0x4c, 0x69, 0x73, 0x74, 0x12, 0x28, 0x0a, 0x08, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x73,
+	0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64,
+	0x49, 0x6e, 0x66, 0x6f, 0x52, 0x08, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x73, 0x22, 0xcc,
+	0x03, 0x0a, 0x12, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x57, 0x72,
+	0x61, 0x70, 0x70, 0x65, 0x72, 0x12, 0x1e, 0x0a, 0x0a, 0x48, 0x74, 0x74, 0x70, 0x4d, 0x65, 0x74,
+	0x68, 0x6f, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x48, 0x74, 0x74, 0x70, 0x4d,
+	0x65, 0x74, 0x68, 0x6f, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x04, 0x42, 0x6f, 0x64, 0x79, 0x12, 0x3a, 0x0a, 0x07, 0x48, 0x65, 0x61,
+	0x64, 0x65, 0x72, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x20, 0x2e, 0x48, 0x74, 0x74,
+	0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x57, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x2e,
+	0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x48, 0x65,
+	0x61, 0x64, 0x65, 0x72, 0x73, 0x12, 0x26, 0x0a, 0x0e, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x55, 0x52, 0x4c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x48,
+	0x74, 0x74, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x55, 0x52, 0x4c, 0x12, 0x22, 0x0a,
+	0x0c, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0c, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49,
+	0x64, 0x12, 0x16, 0x0a, 0x06, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x12, 0x1a, 0x0a, 0x08, 0x44, 0x65, 0x61,
+	0x64, 0x6c, 0x69, 0x6e, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x44, 0x65, 0x61,
+	0x64, 0x6c, 0x69, 0x6e, 0x65, 0x12, 0x1e, 0x0a, 0x0a, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x42,
+	0x6f, 0x64, 0x79, 0x18, 0x08, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x53, 0x74, 0x72, 0x65, 0x61,
+	0x6d, 0x42, 0x6f, 0x64, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x55, 0x70, 0x67, 0x72, 0x61, 0x64, 0x65,
+	0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x55, 0x70, 0x67, 0x72, 0x61, 0x64, 0x65, 0x12,
+	0x25, 0x0a, 0x08, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x18, 0x0a, 0x20, 0x01, 0x28,
+	0x0e, 0x32, 0x09, 0x2e, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x52, 0x08, 0x50, 0x72,
+	0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x12, 0x1c, 0x0a, 0x09, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e,
+	0x64, 0x49, 0x64, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x42, 0x61, 0x63, 0x6b, 0x65,
+	0x6e, 0x64, 0x49, 0x64, 0x1a, 0x47, 0x0a, 0x0c, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x45,
+	0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x21, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x48, 0x74, 0x74, 0x70, 0x48, 0x65, 0x61, 0x64,
+	0x65, 0x72, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x34, 0x0a,
+	0x0a, 0x48, 0x74, 0x74, 0x70, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x10, 0x0a, 0x03, 0x4b,
+	0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x4b, 0x65, 0x79, 0x12, 0x14, 0x0a,
+	0x05, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x05, 0x56, 0x61,
+	0x6c, 0x75, 0x65, 0x22, 0x8b, 0x02, 0x0a, 0x13, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x57, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x42,
+	0x6f, 0x64, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x42, 0x6f, 0x64, 0x79, 0x12,
+	0x3b, 0x0a, 0x07, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x21, 0x2e, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x57,
+	0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x2e, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x45, 0x6e,
+	0x74, 0x72, 0x79, 0x52, 0x07, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x73, 0x12, 0x16, 0x0a, 0x06,
+	0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x53, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x12, 0x22, 0x0a, 0x0c, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69,
+	0x6f, 0x6e, 0x49, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x43, 0x6f, 0x6e, 0x6e,
+	0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x1e, 0x0a, 0x0a, 0x53, 0x74, 0x72, 0x65,
+	0x61, 0x6d, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x53, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x42, 0x6f, 0x64, 0x79, 0x1a, 0x47, 0x0a, 0x0c, 0x48, 0x65, 0x61, 0x64,
+	0x65, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x21, 0x0a, 0x05, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x48, 0x74, 0x74, 0x70,
+	0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38,
+	0x01, 0x22, 0x8b, 0x01, 0x0a, 0x09, 0x42, 0x6f, 0x64, 0x79, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x12,
+	0x22, 0x0a, 0x0c, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f,
+	0x6e, 0x49, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x44, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x04, 0x44, 0x61, 0x74, 0x61, 0x12, 0x10, 0x0a, 0x03, 0x45, 0x6f, 0x66, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x45, 0x6f, 0x66, 0x12, 0x14, 0x0a, 0x05, 0x45, 0x72, 0x72,
+	0x6f, 0x72, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x12,
+	0x1e, 0x0a, 0x0a, 0x53, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x41, 0x64, 0x64, 0x72, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0a, 0x53, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x41, 0x64, 0x64, 0x72, 0x22,
+	0x9f, 0x01, 0x0a, 0x0d, 0x46, 0x72, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x64, 0x46, 0x72, 0x61, 0x6d,
+	0x65, 0x12, 0x2f, 0x0a, 0x07, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x13, 0x2e, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x57, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x48, 0x00, 0x52, 0x07, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x12, 0x32, 0x0a, 0x08, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x57, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x48, 0x00, 0x52, 0x08, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x20, 0x0a, 0x04, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x42, 0x6f, 0x64, 0x79, 0x43, 0x68, 0x75, 0x6e, 0x6b,
+	0x48, 0x00, 0x52, 0x04, 0x42, 0x6f, 0x64, 0x79, 0x42, 0x07, 0x0a, 0x05, 0x46, 0x72, 0x61, 0x6d,
+	0x65, 0x22, 0x9a, 0x02, 0x0a, 0x0b, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x46, 0x72, 0x61, 0x6d,
+	0x65, 0x12, 0x29, 0x0a, 0x08, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e,
+	0x48, 0x00, 0x52, 0x08, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x12, 0x2f, 0x0a, 0x07,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e,
+	0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x57, 0x72, 0x61, 0x70, 0x70,
+	0x65, 0x72, 0x48, 0x00, 0x52, 0x07, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x32, 0x0a,
+	0x08, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x14, 0x2e, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x57, 0x72,
+	0x61, 0x70, 0x70, 0x65, 0x72, 0x48, 0x00, 0x52, 0x08, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x2a, 0x0a, 0x09, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74,
+	0x48, 0x00, 0x52, 0x09, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x12, 0x24, 0x0a,
+	0x07, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x08,
+	0x2e, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x48, 0x00, 0x52, 0x07, 0x43, 0x6f, 0x6e, 0x74,
+	0x72, 0x6f, 0x6c, 0x12, 0x20, 0x0a, 0x04, 0x42, 0x6f, 0x64, 0x79, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x0a, 0x2e, 0x42, 0x6f, 0x64, 0x79, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x48, 0x00, 0x52,
+	0x04, 0x42, 0x6f, 0x64, 0x79, 0x42, 0x07, 0x0a, 0x05, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x22, 0x29,
+	0x0a, 0x09, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x54,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09,
+	0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x22, 0xc4, 0x02, 0x0a, 0x07, 0x43, 0x6f,
+	0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x12, 0x20, 0x0a, 0x04, 0x54, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0e, 0x32, 0x0c, 0x2e, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x54, 0x79, 0x70,
+	0x65, 0x52, 0x04, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x42, 0x61, 0x63, 0x6b, 0x65,
+	0x6e, 0x64, 0x49, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x42, 0x61, 0x63, 0x6b,
+	0x65, 0x6e, 0x64, 0x49, 0x64, 0x12, 0x22, 0x0a, 0x0c, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74,
+	0x69, 0x6f, 0x6e, 0x49, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x43, 0x6f, 0x6e,
+	0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x4d, 0x65, 0x73,
+	0x73, 0x61, 0x67, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x4d, 0x65, 0x73, 0x73,
+	0x61, 0x67, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x06, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x12, 0x39, 0x0a, 0x0d, 0x52,
+	0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x06, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x13, 0x2e, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x52, 0x65, 0x67, 0x69, 0x73,
+	0x74, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0d, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x20, 0x0a, 0x0b, 0x46, 0x6c, 0x6f, 0x77, 0x43, 0x6f,
+	0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x18, 0x07, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0b, 0x46, 0x6c, 0x6f,
+	0x77, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x12, 0x16, 0x0a, 0x06, 0x57, 0x69, 0x6e, 0x64,
+	0x6f, 0x77, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77,
+	0x12, 0x2e, 0x0a, 0x12, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x46, 0x6c, 0x6f, 0x77, 0x43,
+	0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52, 0x12, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x46, 0x6c, 0x6f, 0x77, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c,
+	0x2a, 0x41, 0x0a, 0x08, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x12, 0x11, 0x0a, 0x0d,
+	0x50, 0x52, 0x4f, 0x54, 0x4f, 0x43, 0x4f, 0x4c, 0x5f, 0x48, 0x54, 0x54, 0x50, 0x10, 0x00, 0x12,
+	0x10, 0x0a, 0x0c, 0x50, 0x52, 0x4f, 0x54, 0x4f, 0x43, 0x4f, 0x4c, 0x5f, 0x54, 0x43, 0x50, 0x10,
+	0x01, 0x12, 0x10, 0x0a, 0x0c, 0x50, 0x52, 0x4f, 0x54, 0x4f, 0x43, 0x4f, 0x4c, 0x5f, 0x55, 0x44,
+	0x50, 0x10, 0x02, 0x2a, 0x8c, 0x01, 0x0a, 0x0b, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x54,
+	0x79, 0x70, 0x65, 0x12, 0x13, 0x0a, 0x0f, 0x43, 0x4f, 0x4e, 0x54, 0x52, 0x4f, 0x4c, 0x5f, 0x55,
+	0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12, 0x16, 0x0a, 0x12, 0x43, 0x4f, 0x4e, 0x54,
+	0x52, 0x4f, 0x4c, 0x5f, 0x52, 0x45, 0x47, 0x49, 0x53, 0x54, 0x45, 0x52, 0x45, 0x44, 0x10, 0x01,
+	0x12, 0x12, 0x0a, 0x0e, 0x43, 0x4f, 0x4e, 0x54, 0x52, 0x4f, 0x4c, 0x5f, 0x43, 0x41, 0x4e, 0x43,
+	0x45, 0x4c, 0x10, 0x02, 0x12, 0x11, 0x0a, 0x0d, 0x43, 0x4f, 0x4e, 0x54, 0x52, 0x4f, 0x4c, 0x5f,
+	0x43, 0x4c, 0x4f, 0x53, 0x45, 0x10, 0x03, 0x12, 0x15, 0x0a, 0x11, 0x43, 0x4f, 0x4e, 0x54, 0x52,
+	0x4f, 0x4c, 0x5f, 0x44, 0x49, 0x53, 0x50, 0x4c, 0x41, 0x43, 0x45, 0x44, 0x10, 0x04, 0x12, 0x12,
+	0x0a, 0x0e, 0x43, 0x4f, 0x4e, 0x54, 0x52, 0x4f, 0x4c, 0x5f, 0x57, 0x49, 0x4e, 0x44, 0x4f, 0x57,
+	0x10, 0x05, 0x32, 0xbf, 0x03, 0x0a, 0x05, 0x50, 0x72, 0x6f, 0x78, 0x79, 0x12, 0x3f, 0x0a, 0x10,
+	0x46, 0x72, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x64, 0x45, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e, 0x74,
+	0x12, 0x13, 0x2e, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x57, 0x72,
+	0x61, 0x70, 0x70, 0x65, 0x72, 0x1a, 0x14, 0x2e, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x57, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x22, 0x00, 0x12, 0x36, 0x0a,
+	0x0e, 0x46, 0x72, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x64, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12,
+	0x0e, 0x2e, 0x46, 0x72, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x64, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x1a,
+	0x0e, 0x2e, 0x46, 0x72, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x64, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x22,
+	0x00, 0x28, 0x01, 0x30, 0x01, 0x12, 0x39, 0x0a, 0x0e, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64,
+	0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x12, 0x0b, 0x2e, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63,
+	0x74, 0x69, 0x6f, 0x6e, 0x1a, 0x13, 0x2e, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x57, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x22, 0x03, 0x88, 0x02, 0x01, 0x30, 0x01,
+	0x12, 0x31, 0x0a, 0x0b, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x53, 0x65, 0x6e, 0x64, 0x12,
+	0x14, 0x2e, 0x48, 0x74, 0x74, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x57, 0x72,
+	0x61, 0x70, 0x70, 0x65, 0x72, 0x1a, 0x05, 0x2e, 0x4e, 0x75, 0x6c, 0x6c, 0x22, 0x03, 0x88, 0x02,
+	0x01, 0x28, 0x01, 0x12, 0x2a, 0x0a, 0x06, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x12, 0x0c, 0x2e,
+	0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x1a, 0x0c, 0x2e, 0x54, 0x75,
+	0x6e, 0x6e, 0x65, 0x6c, 0x46, 0x72, 0x61, 0x6d, 0x65, 0x22, 0x00, 0x28, 0x01, 0x30, 0x01, 0x12,
+	0x2a, 0x0a, 0x0c, 0x4c, 0x6f, 0x6f, 0x6b, 0x75, 0x70, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x12,
+	0x0b, 0x2e, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x1a, 0x0b, 0x2e, 0x43,
+	0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x22, 0x00, 0x12, 0x26, 0x0a, 0x0c, 0x57,
+	0x61, 0x74, 0x63, 0x68, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x73, 0x12, 0x05, 0x2e, 0x4e, 0x75,
+	0x6c, 0x6c, 0x1a, 0x0b, 0x2e, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x4c, 0x69, 0x73, 0x74, 0x22,
+	0x00, 0x30, 0x01, 0x12, 0x25, 0x0a, 0x0c, 0x4c, 0x69, 0x73, 0x74, 0x42, 0x61, 0x63, 0x6b, 0x65,
+	0x6e, 0x64, 0x73, 0x12, 0x05, 0x2e, 0x4e, 0x75, 0x6c, 0x6c, 0x1a, 0x0c, 0x2e, 0x42, 0x61, 0x63,
+	0x6b, 0x65, 0x6e, 0x64, 0x4c, 0x69, 0x73, 0x74, 0x22, 0x00, 0x12, 0x28, 0x0a, 0x0d, 0x57, 0x61,
+	0x74, 0x63, 0x68, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x73, 0x12, 0x05, 0x2e, 0x4e, 0x75,
+	0x6c, 0x6c, 0x1a, 0x0c, 0x2e, 0x42, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x4c, 0x69, 0x73, 0x74,
+	0x22, 0x00, 0x30, 0x01, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_remote_proto_rawDescOnce sync.Once
+	file_remote_proto_rawDescData = file_remote_proto_rawDesc
+)
+
+func file_remote_proto_rawDescGZIP() []byte {
+	file_remote_proto_rawDescOnce.Do(func() {
+		file_remote_proto_rawDescData = protoimpl.X.CompressGZIP(file_remote_proto_rawDescData)
+	})
+	return file_remote_proto_rawDescData
+}
+
+var file_remote_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_remote_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
+var file_remote_proto_goTypes = []interface{}{
+	(Protocol)(0),               // 0: Protocol
+	(ControlType)(0),            // 1: ControlType
+	(*Null)(nil),                // 2: Null
+	(*Connection)(nil),          // 3: Connection
+	(*DomainRegistration)(nil),  // 4: DomainRegistration
+	(*DomainList)(nil),          // 5: DomainList
+	(*BackendInfo)(nil),         // 6: BackendInfo
+	(*BackendList)(nil),         // 7: BackendList
+	(*HttpRequestWrapper)(nil),  // 8: HttpRequestWrapper
+	(*HttpHeader)(nil),          // 9: HttpHeader
+	(*HttpResponseWrapper)(nil), // 10: HttpResponseWrapper
+	(*BodyChunk)(nil),           // 11: BodyChunk
+	(*FrontendFrame)(nil),       // 12: FrontendFrame
+	(*TunnelFrame)(nil),         // 13: TunnelFrame
+	(*Heartbeat)(nil),           // 14: Heartbeat
+	(*Control)(nil),             // 15: Control
+	nil,                         // 16: HttpRequestWrapper.HeadersEntry
+	nil,                         // 17: HttpResponseWrapper.HeadersEntry
+}
+var file_remote_proto_depIdxs = []int32{
+	0,  // 0: Connection.Protocol:type_name -> Protocol
+	4,  // 1: Connection.Domains:type_name -> DomainRegistration
+	0,  // 2: DomainRegistration.Protocol:type_name -> Protocol
+	3,  // 3: DomainList.Domains:type_name -> Connection
+	0,  // 4: BackendInfo.Protocol:type_name -> Protocol
+	6,  // 5: BackendList.Backends:type_name -> BackendInfo
+	16, // 6: HttpRequestWrapper.Headers:type_name -> HttpRequestWrapper.HeadersEntry
+	0,  // 7: HttpRequestWrapper.Protocol:type_name -> Protocol
+	17, // 8: HttpResponseWrapper.Headers:type_name -> HttpResponseWrapper.HeadersEntry
+	8,  // 9: FrontendFrame.Request:type_name -> HttpRequestWrapper
+	10, // 10: FrontendFrame.Response:type_name -> HttpResponseWrapper
+	11, // 11: FrontendFrame.Body:type_name -> BodyChunk
+	3,  // 12: TunnelFrame.Register:type_name -> Connection
+	8,  // 13: TunnelFrame.Request:type_name -> HttpRequestWrapper
+	10, // 14: TunnelFrame.Response:type_name -> HttpResponseWrapper
+	14, // 15: TunnelFrame.Heartbeat:type_name -> Heartbeat
+	15, // 16: TunnelFrame.Control:type_name -> Control
+	11, // 17: TunnelFrame.Body:type_name -> BodyChunk
+	1,  // 18: Control.Type:type_name -> ControlType
+	4,  // 19: Control.Registrations:type_name -> DomainRegistration
+	9,  // 20: HttpRequestWrapper.HeadersEntry.value:type_name -> HttpHeader
+	9,  // 21: HttpResponseWrapper.HeadersEntry.value:type_name -> HttpHeader
+	8,  // 22: Proxy.FrontendEndpoint:input_type -> HttpRequestWrapper
+	12, // 23: Proxy.FrontendStream:input_type -> FrontendFrame
+	3,  // 24: Proxy.BackendReceive:input_type -> Connection
+	10, // 25: Proxy.BackendSend:input_type -> HttpResponseWrapper
+	13, // 26: Proxy.Tunnel:input_type -> TunnelFrame
+	3,  // 27: Proxy.LookupDomain:input_type -> Connection
+	2,  // 28: Proxy.WatchDomains:input_type -> Null
+	2,  // 29: Proxy.ListBackends:input_type -> Null
+	2,  // 30: Proxy.WatchBackends:input_type -> Null
+	10, // 31: Proxy.FrontendEndpoint:output_type -> HttpResponseWrapper
+	12, // 32: Proxy.FrontendStream:output_type -> FrontendFrame
+	8,  // 33: Proxy.BackendReceive:output_type -> HttpRequestWrapper
+	2,  // 34: Proxy.BackendSend:output_type -> Null
+	13, // 35: Proxy.Tunnel:output_type -> TunnelFrame
+	3,  // 36: Proxy.LookupDomain:output_type -> Connection
+	5,  // 37: Proxy.WatchDomains:output_type -> DomainList
+	7,  // 38: Proxy.ListBackends:output_type -> BackendList
+	7,  // 39: Proxy.WatchBackends:output_type -> BackendList
+	31, // [31:40] is the sub-list for method output_type
+	22, // [22:31] is the sub-list for method input_type
+	22, // [22:22] is the sub-list for extension type_name
+	22, // [22:22] is the sub-list for extension extendee
+	0,  // [0:22] is the sub-list for field type_name
+}
+
+func init() { file_remote_proto_init() }
+func file_remote_proto_init() {
+	if File_remote_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_remote_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Null); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_remote_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Connection); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_remote_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DomainRegistration); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_remote_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DomainList); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_remote_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BackendInfo); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_remote_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BackendList); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_remote_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HttpRequestWrapper); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_remote_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HttpHeader); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -454,8 +1696,8 @@ func file_remote_proto_init() {
 				return nil
 			}
 		}
-		file_remote_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Connection); i {
+		file_remote_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HttpResponseWrapper); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -466,8 +1708,8 @@ func file_remote_proto_init() {
 				return nil
 			}
 		}
-		file_remote_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*HttpRequestWrapper); i {
+		file_remote_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BodyChunk); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -478,8 +1720,8 @@ func file_remote_proto_init() {
 				return nil
 			}
 		}
-		file_remote_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*HttpHeader); i {
+		file_remote_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FrontendFrame); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -490,8 +1732,32 @@ func file_remote_proto_init() {
 				return nil
 			}
 		}
-		file_remote_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*HttpResponseWrapper); i {
+		file_remote_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TunnelFrame); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_remote_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Heartbeat); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_remote_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Control); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -503,18 +1769,32 @@ func file_remote_proto_init() {
 			}
 		}
 	}
+	file_remote_proto_msgTypes[10].OneofWrappers = []interface{}{
+		(*FrontendFrame_Request)(nil),
+		(*FrontendFrame_Response)(nil),
+		(*FrontendFrame_Body)(nil),
+	}
+	file_remote_proto_msgTypes[11].OneofWrappers = []interface{}{
+		(*TunnelFrame_Register)(nil),
+		(*TunnelFrame_Request)(nil),
+		(*TunnelFrame_Response)(nil),
+		(*TunnelFrame_Heartbeat)(nil),
+		(*TunnelFrame_Control)(nil),
+		(*TunnelFrame_Body)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_remote_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   7,
+			NumEnums:      2,
+			NumMessages:   16,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_remote_proto_goTypes,
 		DependencyIndexes: file_remote_proto_depIdxs,
+		EnumInfos:         file_remote_proto_enumTypes,
 		MessageInfos:      file_remote_proto_msgTypes,
 	}.Build()
 	File_remote_proto = out.File
@@ -536,8 +1816,26 @@ const _ = grpc.SupportPackageIsVersion6
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type ProxyClient interface {
 	FrontendEndpoint(ctx context.Context, in *HttpRequestWrapper, opts ...grpc.CallOption) (*HttpResponseWrapper, error)
+	// ボディをBodyChunkに分けて送受信する
+	// 最初にclientがRequestを送り、relayはResponseを返してからボディを流す
+	FrontendStream(ctx context.Context, opts ...grpc.CallOption) (Proxy_FrontendStreamClient, error)
+	// Deprecated: Do not use.
+	// Deprecated: Tunnelを使う
 	BackendReceive(ctx context.Context, in *Connection, opts ...grpc.CallOption) (Proxy_BackendReceiveClient, error)
+	// Deprecated: Do not use.
+	// Deprecated: Tunnelを使う
 	BackendSend(ctx context.Context, opts ...grpc.CallOption) (Proxy_BackendSendClient, error)
+	// backend-connecterとrelayの間の双方向ストリーム
+	// 最初にbackend-connecterがRegisterを送る
+	Tunnel(ctx context.Context, opts ...grpc.CallOption) (Proxy_TunnelClient, error)
+	// Domainに登録されているバックエンドを返す. 登録されていなければNotFoundを返す
+	LookupDomain(ctx context.Context, in *Connection, opts ...grpc.CallOption) (*Connection, error)
+	// 登録されているドメインの一覧を最初に返し、登録や解除があるたびに一覧を返し直す
+	WatchDomains(ctx context.Context, in *Null, opts ...grpc.CallOption) (Proxy_WatchDomainsClient, error)
+	// 登録されているバックエンドの一覧を返す
+	ListBackends(ctx context.Context, in *Null, opts ...grpc.CallOption) (*BackendList, error)
+	// ListBackendsと同じ一覧を最初に返し、変わるたびに返し直す
+	WatchBackends(ctx context.Context, in *Null, opts ...grpc.CallOption) (Proxy_WatchBackendsClient, error)
 }
 
 type proxyClient struct {
@@ -557,8 +1855,40 @@ func (c *proxyClient) FrontendEndpoint(ctx context.Context, in *HttpRequestWrapp
 	return out, nil
 }
 
+func (c *proxyClient) FrontendStream(ctx context.Context, opts ...grpc.CallOption) (Proxy_FrontendStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Proxy_serviceDesc.Streams[0], "/Proxy/FrontendStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &proxyFrontendStreamClient{stream}
+	return x, nil
+}
+
+type Proxy_FrontendStreamClient interface {
+	Send(*FrontendFrame) error
+	Recv() (*FrontendFrame, error)
+	grpc.ClientStream
+}
+
+type proxyFrontendStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *proxyFrontendStreamClient) Send(m *FrontendFrame) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *proxyFrontendStreamClient) Recv() (*FrontendFrame, error) {
+	m := new(FrontendFrame)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Deprecated: Do not use.
 func (c *proxyClient) BackendReceive(ctx context.Context, in *Connection, opts ...grpc.CallOption) (Proxy_BackendReceiveClient, error) {
-	stream, err := c.cc.NewStream(ctx, &_Proxy_serviceDesc.Streams[0], "/Proxy/BackendReceive", opts...)
+	stream, err := c.cc.NewStream(ctx, &_Proxy_serviceDesc.Streams[1], "/Proxy/BackendReceive", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -589,8 +1919,9 @@ func (x *proxyBackendReceiveClient) Recv() (*HttpRequestWrapper, error) {
 	return m, nil
 }
 
+// Deprecated: Do not use.
 func (c *proxyClient) BackendSend(ctx context.Context, opts ...grpc.CallOption) (Proxy_BackendSendClient, error) {
-	stream, err := c.cc.NewStream(ctx, &_Proxy_serviceDesc.Streams[1], "/Proxy/BackendSend", opts...)
+	stream, err := c.cc.NewStream(ctx, &_Proxy_serviceDesc.Streams[2], "/Proxy/BackendSend", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -623,11 +1954,142 @@ func (x *proxyBackendSendClient) CloseAndRecv() (*Null, error) {
 	return m, nil
 }
 
+func (c *proxyClient) Tunnel(ctx context.Context, opts ...grpc.CallOption) (Proxy_TunnelClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Proxy_serviceDesc.Streams[3], "/Proxy/Tunnel", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &proxyTunnelClient{stream}
+	return x, nil
+}
+
+type Proxy_TunnelClient interface {
+	Send(*TunnelFrame) error
+	Recv() (*TunnelFrame, error)
+	grpc.ClientStream
+}
+
+type proxyTunnelClient struct {
+	grpc.ClientStream
+}
+
+func (x *proxyTunnelClient) Send(m *TunnelFrame) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *proxyTunnelClient) Recv() (*TunnelFrame, error) {
+	m := new(TunnelFrame)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *proxyClient) LookupDomain(ctx context.Context, in *Connection, opts ...grpc.CallOption) (*Connection, error) {
+	out := new(Connection)
+	err := c.cc.Invoke(ctx, "/Proxy/LookupDomain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *proxyClient) WatchDomains(ctx context.Context, in *Null, opts ...grpc.CallOption) (Proxy_WatchDomainsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Proxy_serviceDesc.Streams[4], "/Proxy/WatchDomains", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &proxyWatchDomainsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Proxy_WatchDomainsClient interface {
+	Recv() (*DomainList, error)
+	grpc.ClientStream
+}
+
+type proxyWatchDomainsClient struct {
+	grpc.ClientStream
+}
+
+func (x *proxyWatchDomainsClient) Recv() (*DomainList, error) {
+	m := new(DomainList)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *proxyClient) ListBackends(ctx context.Context, in *Null, opts ...grpc.CallOption) (*BackendList, error) {
+	out := new(BackendList)
+	err := c.cc.Invoke(ctx, "/Proxy/ListBackends", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *proxyClient) WatchBackends(ctx context.Context, in *Null, opts ...grpc.CallOption) (Proxy_WatchBackendsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Proxy_serviceDesc.Streams[5], "/Proxy/WatchBackends", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &proxyWatchBackendsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Proxy_WatchBackendsClient interface {
+	Recv() (*BackendList, error)
+	grpc.ClientStream
+}
+
+type proxyWatchBackendsClient struct {
+	grpc.ClientStream
+}
+
+func (x *proxyWatchBackendsClient) Recv() (*BackendList, error) {
+	m := new(BackendList)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // ProxyServer is the server API for Proxy service.
 type ProxyServer interface {
 	FrontendEndpoint(context.Context, *HttpRequestWrapper) (*HttpResponseWrapper, error)
+	// ボディをBodyChunkに分けて送受信する
+	// 最初にclientがRequestを送り、relayはResponseを返してからボディを流す
+	FrontendStream(Proxy_FrontendStreamServer) error
+	// Deprecated: Do not use.
+	// Deprecated: Tunnelを使う
 	BackendReceive(*Connection, Proxy_BackendReceiveServer) error
+	// Deprecated: Do not use.
+	// Deprecated: Tunnelを使う
 	BackendSend(Proxy_BackendSendServer) error
+	// backend-connecterとrelayの間の双方向ストリーム
+	// 最初にbackend-connecterがRegisterを送る
+	Tunnel(Proxy_TunnelServer) error
+	// Domainに登録されているバックエンドを返す. 登録されていなければNotFoundを返す
+	LookupDomain(context.Context, *Connection) (*Connection, error)
+	// 登録されているドメインの一覧を最初に返し、登録や解除があるたびに一覧を返し直す
+	WatchDomains(*Null, Proxy_WatchDomainsServer) error
+	// 登録されているバックエンドの一覧を返す
+	ListBackends(context.Context, *Null) (*BackendList, error)
+	// ListBackendsと同じ一覧を最初に返し、変わるたびに返し直す
+	WatchBackends(*Null, Proxy_WatchBackendsServer) error
 }
 
 // UnimplementedProxyServer can be embedded to have forward compatible implementations.
@@ -637,12 +2099,30 @@ type UnimplementedProxyServer struct {
 func (*UnimplementedProxyServer) FrontendEndpoint(context.Context, *HttpRequestWrapper) (*HttpResponseWrapper, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method FrontendEndpoint not implemented")
 }
+func (*UnimplementedProxyServer) FrontendStream(Proxy_FrontendStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method FrontendStream not implemented")
+}
 func (*UnimplementedProxyServer) BackendReceive(*Connection, Proxy_BackendReceiveServer) error {
 	return status.Errorf(codes.Unimplemented, "method BackendReceive not implemented")
 }
 func (*UnimplementedProxyServer) BackendSend(Proxy_BackendSendServer) error {
 	return status.Errorf(codes.Unimplemented, "method BackendSend not implemented")
 }
+func (*UnimplementedProxyServer) Tunnel(Proxy_TunnelServer) error {
+	return status.Errorf(codes.Unimplemented, "method Tunnel not implemented")
+}
+func (*UnimplementedProxyServer) LookupDomain(context.Context, *Connection) (*Connection, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LookupDomain not implemented")
+}
+func (*UnimplementedProxyServer) WatchDomains(*Null, Proxy_WatchDomainsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchDomains not implemented")
+}
+func (*UnimplementedProxyServer) ListBackends(context.Context, *Null) (*BackendList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBackends not implemented")
+}
+func (*UnimplementedProxyServer) WatchBackends(*Null, Proxy_WatchBackendsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchBackends not implemented")
+}
 
 func RegisterProxyServer(s *grpc.Server, srv ProxyServer) {
 	s.RegisterService(&_Proxy_serviceDesc, srv)
@@ -666,6 +2146,32 @@ func _Proxy_FrontendEndpoint_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Proxy_FrontendStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ProxyServer).FrontendStream(&proxyFrontendStreamServer{stream})
+}
+
+type Proxy_FrontendStreamServer interface {
+	Send(*FrontendFrame) error
+	Recv() (*FrontendFrame, error)
+	grpc.ServerStream
+}
+
+type proxyFrontendStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *proxyFrontendStreamServer) Send(m *FrontendFrame) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *proxyFrontendStreamServer) Recv() (*FrontendFrame, error) {
+	m := new(FrontendFrame)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _Proxy_BackendReceive_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(Connection)
 	if err := stream.RecvMsg(m); err != nil {
@@ -713,6 +2219,110 @@ func (x *proxyBackendSendServer) Recv() (*HttpResponseWrapper, error) {
 	return m, nil
 }
 
+func _Proxy_Tunnel_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ProxyServer).Tunnel(&proxyTunnelServer{stream})
+}
+
+type Proxy_TunnelServer interface {
+	Send(*TunnelFrame) error
+	Recv() (*TunnelFrame, error)
+	grpc.ServerStream
+}
+
+type proxyTunnelServer struct {
+	grpc.ServerStream
+}
+
+func (x *proxyTunnelServer) Send(m *TunnelFrame) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *proxyTunnelServer) Recv() (*TunnelFrame, error) {
+	m := new(TunnelFrame)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Proxy_LookupDomain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Connection)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProxyServer).LookupDomain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Proxy/LookupDomain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProxyServer).LookupDomain(ctx, req.(*Connection))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Proxy_WatchDomains_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Null)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ProxyServer).WatchDomains(m, &proxyWatchDomainsServer{stream})
+}
+
+type Proxy_WatchDomainsServer interface {
+	Send(*DomainList) error
+	grpc.ServerStream
+}
+
+type proxyWatchDomainsServer struct {
+	grpc.ServerStream
+}
+
+func (x *proxyWatchDomainsServer) Send(m *DomainList) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Proxy_ListBackends_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Null)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProxyServer).ListBackends(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Proxy/ListBackends",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProxyServer).ListBackends(ctx, req.(*Null))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Proxy_WatchBackends_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Null)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ProxyServer).WatchBackends(m, &proxyWatchBackendsServer{stream})
+}
+
+type Proxy_WatchBackendsServer interface {
+	Send(*BackendList) error
+	grpc.ServerStream
+}
+
+type proxyWatchBackendsServer struct {
+	grpc.ServerStream
+}
+
+func (x *proxyWatchBackendsServer) Send(m *BackendList) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _Proxy_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "Proxy",
 	HandlerType: (*ProxyServer)(nil),
@@ -721,8 +2331,22 @@ var _Proxy_serviceDesc = grpc.ServiceDesc{
 			MethodName: "FrontendEndpoint",
 			Handler:    _Proxy_FrontendEndpoint_Handler,
 		},
+		{
+			MethodName: "LookupDomain",
+			Handler:    _Proxy_LookupDomain_Handler,
+		},
+		{
+			MethodName: "ListBackends",
+			Handler:    _Proxy_ListBackends_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "FrontendStream",
+			Handler:       _Proxy_FrontendStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 		{
 			StreamName:    "BackendReceive",
 			Handler:       _Proxy_BackendReceive_Handler,
@@ -733,6 +2357,22 @@ var _Proxy_serviceDesc = grpc.ServiceDesc{
 			Handler:       _Proxy_BackendSend_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "Tunnel",
+			Handler:       _Proxy_Tunnel_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchDomains",
+			Handler:       _Proxy_WatchDomains_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchBackends",
+			Handler:       _Proxy_WatchBackends_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "remote.proto",
 }
@@ -0,0 +1,5 @@
+package remote
+
+// BodyWindow はCONTROL_WINDOWを待たずに送ってよいBodyChunkの数
+// レスポンスはrelayが、リクエストはbackend-connecterが接続ごとにこの数だけBodyChunkを溜めておける
+const BodyWindow = 16